@@ -1,67 +1,71 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"github.com/khatibomar/dhangkanna/internal/agent"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/metrics"
+	"github.com/khatibomar/dhangkanna/internal/status"
+	"github.com/khatibomar/dhangkanna/internal/telemetry"
 	"io"
 	"log"
-	"net"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"strings"
 	"syscall"
 )
 
-var dataDir = path.Join(os.TempDir(), "dhangkanna")
-
 func main() {
-	logger := log.New(os.Stdout, "server: ", log.LstdFlags|log.Lshortfile)
 	if err := run(); err != nil {
-		logger.Fatal(err)
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
 	}
 }
 
 func run() error {
 	cfg := agent.Config{}
-	parse(&cfg)
-
-	_ = os.Mkdir(dataDir, 0666)
+	traceCfg := telemetry.Config{ServiceName: "dhangkanna-backend"}
+	logCfg := logging.Config{Output: os.Stdout}
+	parse(&cfg, &traceCfg, &logCfg)
 
-	addr, err := getRpcAddress(cfg)
+	logger, err := logging.New(logCfg)
 	if err != nil {
 		return err
 	}
+	// libraries logging through slog's default end up in the same place.
+	slog.SetDefault(logger)
+	cfg.Logger = logger
 
-	if err := storeServerAddress(addr); err != nil {
+	traceCfg.InstanceID = cfg.NodeName
+	shutdownTracing, err := telemetry.Setup(context.Background(), traceCfg)
+	if err != nil {
 		return err
 	}
-
-	defer func(addr string) {
-		err := removeServerFromDB(addr)
-		log.Printf("Removing %s from bucket\n", addr)
-		if err != nil {
-			log.Println(err)
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("flushing traces", "error", err)
 		}
-	}(addr)
+	}()
 
 	a, err := agent.New(cfg)
 	if err != nil {
 		return err
 	}
 	go func() {
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			j, err := json.Marshal(a.DistributedGame.Game)
-			if err != nil {
-				log.Fatal(err)
-			}
-			_, _ = io.WriteString(w, string(j))
+		http.Handle("/", status.HTMLHandler(a.Status))
+		http.Handle("/status", status.JSONHandler(a.Status))
+		http.HandleFunc("/game", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(a.DistributedGame.Copy())
 		})
+		http.Handle("/metrics", metrics.Handler())
+		http.HandleFunc("/healthz", healthHandler(a.DistributedGame.Live))
+		http.HandleFunc("/readyz", healthHandler(a.DistributedGame.Ready))
 
 		_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", a.Config.RPCPort+1), nil)
 	}()
@@ -72,7 +76,7 @@ func run() error {
 	return a.Shutdown()
 }
 
-func parse(cfg *agent.Config) {
+func parse(cfg *agent.Config, traceCfg *telemetry.Config, logCfg *logging.Config) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatal(err)
@@ -93,76 +97,68 @@ func parse(cfg *agent.Config) {
 		"Serf addresses to join.")
 
 	flag.BoolVar(&cfg.Bootstrap, "bootstrap", false, "Bootstrap the cluster.")
+	flag.StringVar(&cfg.Role, "role", "voter", "Raft role of the server, voter or nonvoter (read replica).")
+	flag.DurationVar(&cfg.SnapshotInterval, "snapshot-interval",
+		0,
+		"How often raft checks if it should snapshot, defaults to raft's 120s.")
+	flag.Uint64Var(&cfg.SnapshotThreshold, "snapshot-threshold",
+		0,
+		"Number of outstanding logs before a snapshot is taken, defaults to raft's 8192.")
+	flag.IntVar(&cfg.SnapshotRetain, "snapshot-retain",
+		1,
+		"Number of snapshots to keep on disk.")
+	flag.StringVar(&cfg.RecoverPeersPath, "recover-peers",
+		"",
+		"Path to a peers.json file, forces its configuration on the local raft state to recover from a lost quorum.")
+	flag.StringVar(&cfg.TLSCAFile, "tls-ca-file", "", "CA used to verify peer certificates.")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert-file", "", "Certificate presented to peers.")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key-file", "", "Key of the certificate presented to peers.")
+	flag.StringVar(&cfg.GossipKey, "gossip-key", "", "Base64 encoded key used to encrypt serf gossip.")
+	var gossipKeyFile string
+	flag.StringVar(&gossipKeyFile, "gossip-key-file", "", "File holding the base64 encoded gossip key, takes precedence over -gossip-key.")
+	flag.StringVar(&cfg.GossipKeyringFile, "gossip-keyring-file",
+		"",
+		"File persisting the gossip keyring, defaults to <data-dir>/serf/keyring when encryption is enabled.")
+	flag.StringVar(&cfg.AuthTokensFile, "auth-tokens-file", "", "JSON file listing static bearer tokens and their roles, enables auth.")
+	flag.StringVar(&cfg.AuthSecretFile, "auth-secret-file", "", "File holding the HMAC secret of signed bearer tokens, enables auth.")
+	flag.Float64Var(&cfg.GuessRateLimit, "rate-limit",
+		0,
+		"Guesses per second allowed per client (token subject or IP), 0 disables the limit.")
+	flag.IntVar(&cfg.GuessRateBurst, "rate-burst", 10, "Guesses a client can send in a burst.")
+	flag.Uint64Var(&cfg.ReadyMaxLag, "ready-max-lag",
+		16,
+		"Committed raft entries a node can have left to apply and still be ready.")
+	flag.BoolVar(&cfg.Reflection, "reflection", false, "Serve gRPC reflection, for tools like grpcurl.")
+	flag.StringVar(&logCfg.Level, "log-level", "info", "Minimum level logged: debug, info, warn or error.")
+	flag.StringVar(&logCfg.Format, "log-format", logging.FormatText, "Log format: text or json.")
+	flag.StringVar(&traceCfg.Exporter, "trace-exporter", "", "Where to export traces: otlp, stdout or file, empty disables tracing.")
+	flag.StringVar(&traceCfg.OTLPEndpoint, "trace-otlp-endpoint", "", "host:port of the OTLP gRPC collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT.")
+	flag.BoolVar(&traceCfg.OTLPInsecure, "trace-otlp-insecure", false, "Send traces to the OTLP collector without TLS.")
+	flag.StringVar(&traceCfg.File, "trace-file", "", "File receiving spans as JSON lines with -trace-exporter=file.")
 
 	flag.Parse()
 
 	if startAddrs != "" {
 		cfg.StartJoinAddrs = strings.Split(startAddrs, ",")
 	}
-}
 
-func removeServerFromDB(addr string) error {
-	db, err := bolt.Open(path.Join(dataDir, "serverlist.db"), 0600, nil)
-	if err != nil {
-		return err
-	}
-	defer func(db *bolt.DB) {
-		_ = db.Close()
-	}(db)
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("ServerAddresses"))
-		if bucket == nil {
-			return fmt.Errorf("Bucket not found")
-		}
-
-		if err := bucket.Delete([]byte(addr)); err != nil {
-			return err
+	if gossipKeyFile != "" {
+		b, err := os.ReadFile(gossipKeyFile)
+		if err != nil {
+			log.Fatal(err)
 		}
-		return nil
-	})
-
-	if err != nil {
-		return err
+		cfg.GossipKey = strings.TrimSpace(string(b))
 	}
-
-	return nil
 }
 
-func storeServerAddress(addr string) error {
-	db, err := bolt.Open(path.Join(dataDir, "serverlist.db"), 0600, nil)
-	if err != nil {
-		return err
-	}
-	defer func(db *bolt.DB) {
-		err := db.Close()
-		if err != nil {
-			log.Println(err)
-		}
-	}(db)
-
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte("ServerAddresses"))
-		if err != nil {
-			return err
+// healthHandler answers 200 when check passes and 503 with the reason
+// otherwise.
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
 		}
-		id, _ := bucket.NextSequence()
-		key := itob(id)
-		return bucket.Put(key, []byte(addr))
-	})
-}
-
-func getRpcAddress(cfg agent.Config) (string, error) {
-	host, _, err := net.SplitHostPort(cfg.BindAddr)
-	if err != nil {
-		return "", err
+		_, _ = io.WriteString(w, "ok\n")
 	}
-
-	return fmt.Sprintf("%s:%d", host, cfg.RPCPort), nil
-}
-
-func itob(v uint64) []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, v)
-	return b
 }
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/backup"
+	tlsconfig "github.com/khatibomar/dhangkanna/internal/config"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const usage = `dhangctl is a tool to manage a dhangkanna cluster.
+
+Usage:
+
+	dhangctl [flags] <command> [arguments]
+
+Commands:
+
+	servers                    list the servers in the cluster
+	add-voter <id> <addr>      add a voting server to the cluster
+	add-nonvoter <id> <addr>   add a non-voting server to the cluster
+	remove-server <id>         remove a server from the cluster
+	demote-voter <id>          turn a voter into a nonvoter
+	transfer-leadership [id]   hand leadership over to another server
+	snapshot                   force the server at -addr to take a snapshot
+	snapshots                  list the snapshots retained by the server at -addr
+	backup <file>              save the latest snapshot of the leader to file
+	restore <file>             replace the cluster state with a backup
+	keygen                     generate a new gossip encryption key
+	keys list                  list the gossip keys installed in the cluster
+	keys install <key>         install a new gossip key on every member
+	keys use <key>             make an installed key the primary one
+	keys remove <key>          remove a gossip key from every member
+	token [-ttl] -secret-file <file> <subject> <role>
+	                           mint a signed bearer token for player, moderator or admin
+
+Flags:
+
+`
+
+type config struct {
+	addr        string
+	timeout     time.Duration
+	tlsCAFile   string
+	tlsCertFile string
+	tlsKeyFile  string
+	token       string
+}
+
+func main() {
+	logger := log.New(os.Stderr, "dhangctl: ", 0)
+	cfg := config{}
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:4002", "RPC address of any server in the cluster.")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "Timeout for the command.")
+	flag.StringVar(&cfg.tlsCAFile, "tls-ca-file", "", "CA used to verify server certificates, enables TLS.")
+	flag.StringVar(&cfg.tlsCertFile, "tls-cert-file", "", "Client certificate presented to servers.")
+	flag.StringVar(&cfg.tlsKeyFile, "tls-key-file", "", "Key of the client certificate presented to servers.")
+	flag.StringVar(&cfg.token, "token", os.Getenv("DHANGKANNA_TOKEN"), "Bearer token, defaults to $DHANGKANNA_TOKEN.")
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(cfg, flag.Arg(0), flag.Args()[1:]); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func run(cfg config, cmd string, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	switch cmd {
+	case "servers":
+		return listServers(ctx, cfg)
+	case "add-voter":
+		if len(args) != 2 {
+			return errors.New("usage: add-voter <id> <addr>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			_, err := c.AddVoter(ctx, &api.AddServerRequest{Id: args[0], RpcAddr: args[1]})
+			return err
+		})
+	case "add-nonvoter":
+		if len(args) != 2 {
+			return errors.New("usage: add-nonvoter <id> <addr>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			_, err := c.AddNonvoter(ctx, &api.AddServerRequest{Id: args[0], RpcAddr: args[1]})
+			return err
+		})
+	case "remove-server":
+		if len(args) != 1 {
+			return errors.New("usage: remove-server <id>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			_, err := c.RemoveServer(ctx, &api.RemoveServerRequest{Id: args[0]})
+			return err
+		})
+	case "demote-voter":
+		if len(args) != 1 {
+			return errors.New("usage: demote-voter <id>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			_, err := c.DemoteVoter(ctx, &api.DemoteVoterRequest{Id: args[0]})
+			return err
+		})
+	case "transfer-leadership":
+		if len(args) > 1 {
+			return errors.New("usage: transfer-leadership [id]")
+		}
+		req := &api.TransferLeadershipRequest{}
+		if len(args) == 1 {
+			req.Id = args[0]
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			_, err := c.TransferLeadership(ctx, req)
+			return err
+		})
+	case "snapshot":
+		return withNode(cfg, func(c api.AdminServiceClient) error {
+			meta, err := c.Snapshot(ctx, &api.SnapshotRequest{})
+			if err != nil {
+				return err
+			}
+			printSnapshots(meta)
+			return nil
+		})
+	case "snapshots":
+		return withNode(cfg, func(c api.AdminServiceClient) error {
+			res, err := c.ListSnapshots(ctx, &api.ListSnapshotsRequest{})
+			if err != nil {
+				return err
+			}
+			printSnapshots(res.Snapshots...)
+			return nil
+		})
+	case "backup":
+		if len(args) != 1 {
+			return errors.New("usage: backup <file>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			return backupTo(ctx, c, args[0])
+		})
+	case "restore":
+		if len(args) != 1 {
+			return errors.New("usage: restore <file>")
+		}
+		return withAdmin(ctx, cfg, func(c api.AdminServiceClient) error {
+			return restoreFrom(ctx, c, args[0])
+		})
+	case "keygen":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return nil
+	case "token":
+		return mintToken(args)
+	case "keys":
+		return withNode(cfg, func(c api.AdminServiceClient) error {
+			return manageKeys(ctx, c, args)
+		})
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func listServers(ctx context.Context, cfg config) error {
+	conn, err := dial(cfg, cfg.addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	servers, err := loadbalance.ListServers(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		role := "follower"
+		if s.IsLeader {
+			role = "leader"
+		} else if s.IsNonvoter {
+			role = "nonvoter"
+		}
+		fmt.Printf("%s\t%s\t%s\n", s.Id, s.RpcAddr, role)
+	}
+	return nil
+}
+
+// withAdmin runs fn against the leader, membership changes are only
+// accepted by the leader so we look it up from the given address first.
+func withAdmin(ctx context.Context, cfg config, fn func(api.AdminServiceClient) error) error {
+	leader, err := findLeader(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	conn, err := dial(cfg, leader)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return fn(api.NewAdminServiceClient(conn))
+}
+
+// withNode runs fn against the server at -addr, snapshots are local to
+// every server so there is no need to go through the leader.
+func withNode(cfg config, fn func(api.AdminServiceClient) error) error {
+	conn, err := dial(cfg, cfg.addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return fn(api.NewAdminServiceClient(conn))
+}
+
+func printSnapshots(snapshots ...*api.SnapshotMeta) {
+	for _, s := range snapshots {
+		fmt.Printf("%s\tindex=%d\tterm=%d\tsize=%d\n", s.Id, s.Index, s.Term, s.Size)
+	}
+}
+
+func backupTo(ctx context.Context, c api.AdminServiceClient, path string) (err error) {
+	stream, err := c.Backup(ctx, &api.BackupRequest{})
+	if err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Meta == nil {
+		return errors.New("backup stream didn't start with the snapshot meta")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+
+	meta := backup.Meta{
+		ID:        first.Meta.Id,
+		Index:     first.Meta.Index,
+		Term:      first.Meta.Term,
+		Size:      first.Meta.Size,
+		Version:   first.Meta.Version,
+		CreatedAt: time.Now().UTC(),
+	}
+	r := backup.NewChunkReader(first.Data, func() ([]byte, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Data, nil
+	})
+	if err := backup.Write(f, meta, r); err != nil {
+		return err
+	}
+	fmt.Printf("saved snapshot %s (index=%d term=%d size=%d) to %s\n", meta.ID, meta.Index, meta.Term, meta.Size, path)
+	return nil
+}
+
+func restoreFrom(ctx context.Context, c api.AdminServiceClient, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	meta, state, err := backup.Read(f)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.Restore(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&api.SnapshotChunk{Meta: &api.SnapshotMeta{
+		Id:      meta.ID,
+		Index:   meta.Index,
+		Term:    meta.Term,
+		Size:    meta.Size,
+		Version: meta.Version,
+	}}); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := state.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&api.SnapshotChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return err
+	}
+	fmt.Printf("restored snapshot %s (index=%d term=%d) from %s\n", meta.ID, meta.Index, meta.Term, path)
+	return nil
+}
+
+func mintToken(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	secretFile := fs.String("secret-file", "", "File holding the HMAC secret the servers use.")
+	ttl := fs.Duration("ttl", 0, "How long the token is valid, 0 never expires.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *secretFile == "" || fs.NArg() != 2 {
+		return errors.New("usage: token [-ttl=24h] -secret-file=<file> <subject> <role>")
+	}
+	role, err := auth.ParseRole(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	secret, err := os.ReadFile(*secretFile)
+	if err != nil {
+		return err
+	}
+	token, err := auth.NewSigner(bytes.TrimSpace(secret)).Sign(auth.Identity{
+		Subject: fs.Arg(0),
+		Role:    role,
+	}, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+func manageKeys(ctx context.Context, c api.AdminServiceClient, args []string) error {
+	var res *api.KeyringResponse
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		res, err = c.ListKeys(ctx, &api.ListKeysRequest{})
+	case len(args) == 2 && args[0] == "install":
+		res, err = c.InstallKey(ctx, &api.KeyRequest{Key: args[1]})
+	case len(args) == 2 && args[0] == "use":
+		res, err = c.UseKey(ctx, &api.KeyRequest{Key: args[1]})
+	case len(args) == 2 && args[0] == "remove":
+		res, err = c.RemoveKey(ctx, &api.KeyRequest{Key: args[1]})
+	default:
+		return errors.New("usage: keys list | keys install <key> | keys use <key> | keys remove <key>")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d/%d nodes responded\n", res.NumResp, res.NumNodes)
+	for key, n := range res.Keys {
+		primary := ""
+		if _, ok := res.PrimaryKeys[key]; ok {
+			primary = "\tprimary"
+		}
+		fmt.Printf("%s\t[%d/%d]%s\n", key, n, res.NumNodes, primary)
+	}
+	return nil
+}
+
+const chunkSize = 64 * 1024
+
+func findLeader(ctx context.Context, cfg config) (string, error) {
+	conn, err := dial(cfg, cfg.addr)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	servers, err := loadbalance.ListServers(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range servers {
+		if s.IsLeader {
+			return s.RpcAddr, nil
+		}
+	}
+	return "", errors.New("cluster has no leader")
+}
+
+func dial(cfg config, addr string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.tlsCAFile != "" {
+		tlsConfig, err := tlsconfig.SetupTLSConfig(tlsconfig.TLSConfig{
+			CertFile: cfg.tlsCertFile,
+			KeyFile:  cfg.tlsKeyFile,
+			CAFile:   cfg.tlsCAFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if cfg.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.BearerToken(cfg.token, cfg.tlsCAFile != "")))
+	}
+	return grpc.Dial(addr, opts...)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/khatibomar/dhangkanna/internal/discovery"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+var _ discovery.Handler = (*backends)(nil)
+
+// backends is the live list of backend RPC addresses, it follows the serf
+// membership when the frontend joins the cluster, or holds the addresses
+// passed in -backend-addr.
+type backends struct {
+	mu    sync.RWMutex
+	addrs map[string]string
+}
+
+func newBackends(static []string) *backends {
+	b := &backends{addrs: make(map[string]string)}
+	for _, addr := range static {
+		b.addrs[addr] = addr
+	}
+	return b
+}
+
+// Join records the RPC address a server advertises in its tags, the
+// frontend doesn't care whether it votes.
+func (b *backends) Join(name, addr string, _ bool) error {
+	if addr == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addrs[name] = addr
+	return nil
+}
+
+func (b *backends) Leave(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.addrs, name)
+	return nil
+}
+
+// has reports whether a server still advertises addr.
+func (b *backends) has(addr string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, a := range b.addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *backends) list() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	addrs := make([]string, 0, len(b.addrs))
+	for _, addr := range b.addrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// random picks a backend so frontends spread their load, the load
+// balancer routes calls to the right server from there.
+func (b *backends) random() (string, bool) {
+	addrs := b.list()
+	if len(addrs) == 0 {
+		return "", false
+	}
+	return addrs[rand.Intn(len(addrs))], true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	"github.com/khatibomar/dhangkanna/internal/client"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	minRedialDelay = time.Second
+	maxRedialDelay = 30 * time.Second
+	// drainTimeout is how long a replaced connection stays open for the
+	// calls players still have running on it.
+	drainTimeout = 30 * time.Second
+)
+
+var errConnClosed = errors.New("backend connection closed")
+
+// backendConn is the single connection the frontend shares for all of its
+// players. The load balancer behind it follows the servers of the cluster
+// and reconnects to them, so it is only dialed again through another
+// backend when the one it went through leaves or every server fails.
+type backendConn struct {
+	backends *backends
+	config   client.Config
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	conn   *grpc.ClientConn
+	addr   string
+	closed bool
+	// redials counts the dials in a row done for a failing connection, it
+	// doubles the wait before the next one.
+	redials  int
+	dialedAt time.Time
+	// draining are the replaced connections waiting for their calls to
+	// finish before being closed.
+	draining map[*grpc.ClientConn]*time.Timer
+}
+
+func newBackendConn(backends *backends, config client.Config, logger *slog.Logger) *backendConn {
+	return &backendConn{
+		backends: backends,
+		config:   config,
+		logger:   logging.Component(logger, "conn"),
+	}
+}
+
+func (c *backendConn) client() (dhangkannav1.GameServiceClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errConnClosed
+	}
+	if c.conn != nil && !c.shouldRedial() {
+		return dhangkannav1.NewGameServiceClient(c.conn), nil
+	}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return dhangkannav1.NewGameServiceClient(c.conn), nil
+}
+
+func (c *backendConn) shouldRedial() bool {
+	if !c.backends.has(c.addr) {
+		return true
+	}
+	switch c.conn.GetState() {
+	case connectivity.Shutdown:
+		return true
+	case connectivity.TransientFailure:
+		// give grpc the time to reconnect before going through another
+		// backend.
+		return time.Since(c.dialedAt) >= c.redialDelay()
+	case connectivity.Ready:
+		c.redials = 0
+	}
+	return false
+}
+
+func (c *backendConn) redialDelay() time.Duration {
+	delay := minRedialDelay << c.redials
+	if delay <= 0 || delay > maxRedialDelay {
+		return maxRedialDelay
+	}
+	return delay
+}
+
+// dial replaces the connection, the old one is closed once the calls still
+// running on it had the time to finish.
+func (c *backendConn) dial() error {
+	addr, ok := c.backends.random()
+	if !ok {
+		return errors.New("no backend servers found")
+	}
+	conn, err := client.Dial(addr, c.config)
+	if err != nil {
+		return err
+	}
+	if c.conn != nil {
+		c.redials++
+		c.logger.Info("redialing the cluster", "from", c.addr, "to", addr, "state", c.conn.GetState().String())
+		c.drain(c.conn, c.addr)
+	}
+	c.conn, c.addr, c.dialedAt = conn, addr, time.Now()
+	return nil
+}
+
+// drain closes conn after drainTimeout, c.mu must be held.
+func (c *backendConn) drain(conn *grpc.ClientConn, addr string) {
+	if c.draining == nil {
+		c.draining = make(map[*grpc.ClientConn]*time.Timer)
+	}
+	c.draining[conn] = time.AfterFunc(drainTimeout, func() {
+		c.mu.Lock()
+		delete(c.draining, conn)
+		c.mu.Unlock()
+		if err := conn.Close(); err != nil {
+			c.logger.Warn("closing connection", "addr", addr, "error", err)
+		}
+	})
+}
+
+func (c *backendConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var errs []error
+	for conn, timer := range c.draining {
+		if timer.Stop() {
+			errs = append(errs, conn.Close())
+		}
+	}
+	c.draining = nil
+	if c.conn != nil {
+		errs = append(errs, c.conn.Close())
+		c.conn = nil
+	}
+	return errors.Join(errs...)
+}
@@ -53,6 +53,7 @@ ws.onmessage = (event) => {
             updateGame(state);
             break;
         case "notification":
+            showGameState(message.content, 'orange');
             break;
     }
 };
@@ -2,10 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/khatibomar/dhangkanna/internal/client"
+	"github.com/khatibomar/dhangkanna/internal/config"
+	"github.com/khatibomar/dhangkanna/internal/discovery"
+	"github.com/khatibomar/dhangkanna/internal/gateway"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"github.com/khatibomar/dhangkanna/internal/telemetry"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,8 +31,20 @@ var staticFolder embed.FS
 var gameHTML embed.FS
 
 type serverConfig struct {
-	port        int
-	backendAddr []string
+	port           int
+	backendAddr    []string
+	nodeName       string
+	bindAddr       string
+	startJoinAddrs []string
+	gossipKey      string
+	tlsCAFile      string
+	tlsCertFile    string
+	tlsKeyFile     string
+	token          string
+	rateLimit      float64
+	rateBurst      int
+	trace          telemetry.Config
+	log            logging.Config
 }
 
 func main() {
@@ -29,19 +52,72 @@ func main() {
 	flag.IntVar(&cfg.port, "port", 4000, "port that socket will run on")
 	var addrs string
 	flag.StringVar(&addrs, "backend-addr", "", "backend addresses are comma seperated, use in case you don't need to auto pick one")
+	hostname, _ := os.Hostname()
+	flag.StringVar(&cfg.nodeName, "node-name", "", "serf name of the frontend, defaults to frontend-<hostname>-<port>")
+	flag.StringVar(&cfg.bindAddr, "bind-addr", "127.0.0.1:4010", "address to bind serf on")
+	var startAddrs string
+	flag.StringVar(&startAddrs, "start-join-addrs", "", "serf addresses of backends to join, comma seperated, the frontend follows the cluster membership from there")
+	flag.StringVar(&cfg.gossipKey, "gossip-key", "", "base64 encoded key used to encrypt serf gossip")
+	var gossipKeyFile string
+	flag.StringVar(&gossipKeyFile, "gossip-key-file", "", "file holding the base64 encoded gossip key, takes precedence over -gossip-key")
+	flag.StringVar(&cfg.tlsCAFile, "tls-ca-file", "", "CA used to verify backend certificates, enables TLS")
+	flag.StringVar(&cfg.tlsCertFile, "tls-cert-file", "", "client certificate presented to backends")
+	flag.StringVar(&cfg.tlsKeyFile, "tls-key-file", "", "key of the client certificate presented to backends")
+	flag.StringVar(&cfg.token, "token", "", "bearer token sent to backends, it needs the moderator role to restart games")
+	var tokenFile string
+	flag.StringVar(&tokenFile, "token-file", "", "file holding the bearer token, takes precedence over -token")
+	flag.Float64Var(&cfg.rateLimit, "rate-limit", 2, "messages per second allowed per player IP, 0 disables the limit")
+	flag.IntVar(&cfg.rateBurst, "rate-burst", 5, "messages a player can send in a burst")
+	flag.StringVar(&cfg.log.Level, "log-level", "info", "minimum level logged: debug, info, warn or error")
+	flag.StringVar(&cfg.log.Format, "log-format", logging.FormatText, "log format: text or json")
+	flag.StringVar(&cfg.trace.Exporter, "trace-exporter", "", "where to export traces: otlp, stdout or file, empty disables tracing")
+	flag.StringVar(&cfg.trace.OTLPEndpoint, "trace-otlp-endpoint", "", "host:port of the OTLP gRPC collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	flag.BoolVar(&cfg.trace.OTLPInsecure, "trace-otlp-insecure", false, "send traces to the OTLP collector without TLS")
+	flag.StringVar(&cfg.trace.File, "trace-file", "", "file receiving spans as JSON lines with -trace-exporter=file")
 	flag.Parse()
 
 	if addrs != "" {
 		cfg.backendAddr = strings.Split(addrs, ",")
 	}
+	if startAddrs != "" {
+		cfg.startJoinAddrs = strings.Split(startAddrs, ",")
+	}
+	if cfg.nodeName == "" {
+		cfg.nodeName = fmt.Sprintf("frontend-%s-%d", hostname, cfg.port)
+	}
+
+	cfg.log.Output = os.Stdout
+	logger, err := logging.New(cfg.log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	serverLogger := logging.Component(logger, "frontend")
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			serverLogger.Error("reading token file", "error", err)
+			os.Exit(1)
+		}
+		cfg.token = strings.TrimSpace(string(b))
+	}
+	if gossipKeyFile != "" {
+		b, err := os.ReadFile(gossipKeyFile)
+		if err != nil {
+			serverLogger.Error("reading gossip key file", "error", err)
+			os.Exit(1)
+		}
+		cfg.gossipKey = strings.TrimSpace(string(b))
+	}
 
-	serverLogger := log.New(os.Stdout, "frontend: ", log.LstdFlags|log.Lshortfile)
-	if err := serve(cfg, serverLogger); err != nil {
-		serverLogger.Fatalf("%v", err)
+	if err := serve(cfg, logger); err != nil {
+		serverLogger.Error("frontend failed", "error", err)
+		os.Exit(1)
 	}
 }
 
-func serve(cfg *serverConfig, serverLogger *log.Logger) error {
+func serve(cfg *serverConfig, logger *slog.Logger) error {
+	serverLogger := logging.Component(logger, "frontend")
 	fs := http.FileServer(http.FS(staticFolder))
 
 	http.Handle("/static/", http.StripPrefix("/", fs))
@@ -60,20 +136,85 @@ func serve(cfg *serverConfig, serverLogger *log.Logger) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	n, err := NewSocket(ctx, cfg.backendAddr)
+	cfg.trace.ServiceName = "dhangkanna-frontend"
+	cfg.trace.InstanceID = cfg.nodeName
+	shutdownTracing, err := telemetry.Setup(ctx, cfg.trace)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			serverLogger.Error("flushing traces", "error", err)
+		}
+	}()
+
+	var tlsConfig *tls.Config
+	if cfg.tlsCAFile != "" {
+		var err error
+		tlsConfig, err = config.SetupTLSConfig(config.TLSConfig{
+			CertFile: cfg.tlsCertFile,
+			KeyFile:  cfg.tlsKeyFile,
+			CAFile:   cfg.tlsCAFile,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var limiter *ratelimit.Limiter
+	if cfg.rateLimit > 0 {
+		limiter = ratelimit.New(cfg.rateLimit, cfg.rateBurst)
+	}
+
+	backends, leave, err := setupBackends(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := leave(); err != nil {
+			serverLogger.Error("leaving the cluster", "error", err)
+		}
+	}()
+
+	conn := newBackendConn(backends, client.Config{
+		TLSConfig: tlsConfig,
+		Token:     cfg.token,
+		Logger:    logger,
+	}, logger)
+	defer func() {
+		if err := conn.Close(); err != nil {
+			serverLogger.Error("closing backend connection", "error", err)
+		}
+	}()
+
+	n, err := NewSocket(ctx, conn, limiter, logger)
 	if err != nil {
 		return err
 	}
 
 	http.HandleFunc("/ws", n.HandleWebSocket)
 
+	rest := gateway.Handler(gateway.Config{
+		Client:  conn.client,
+		Limiter: limiter,
+		// players on the page see the changes made through the API.
+		OnChange: func(ctx context.Context) {
+			if err := n.sendGameState(ctx); err != nil {
+				serverLogger.Warn("sending game state", "error", err)
+			}
+		},
+		Logger: logger,
+	})
+	http.Handle("/games/", rest)
+	http.Handle("/cluster/", rest)
+
 	address := fmt.Sprintf(":%d", cfg.port)
 
-	serverLogger.Printf("Server is running on port %d\n", cfg.port)
+	serverLogger.Info("server is running", "port", cfg.port)
 	go func() {
 		if err := http.ListenAndServe(address, nil); err != nil {
 			if err != nil {
-				serverLogger.Println(err)
+				serverLogger.Error("serving http", "error", err)
 			}
 		}
 	}()
@@ -83,3 +224,36 @@ func serve(cfg *serverConfig, serverLogger *log.Logger) error {
 	<-sigc
 	return nil
 }
+
+// setupBackends uses the addresses of -backend-addr when given, otherwise it
+// joins serf as a frontend member to follow the servers of the cluster. The
+// returned function leaves the cluster.
+func setupBackends(cfg *serverConfig, logger *slog.Logger) (*backends, func() error, error) {
+	if len(cfg.backendAddr) > 0 {
+		return newBackends(cfg.backendAddr), func() error { return nil }, nil
+	}
+	if len(cfg.startJoinAddrs) == 0 {
+		return nil, nil, errors.New("either -backend-addr or -start-join-addrs is required")
+	}
+	var encryptKey []byte
+	if cfg.gossipKey != "" {
+		var err error
+		encryptKey, err = base64.StdEncoding.DecodeString(cfg.gossipKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding gossip key: %w", err)
+		}
+	}
+	b := newBackends(nil)
+	d, err := discovery.New(b, discovery.Config{
+		NodeName:            cfg.nodeName,
+		BindAddr:            cfg.bindAddr,
+		Tags:                map[string]string{"role": discovery.RoleFrontend},
+		StartJoinsAddresses: cfg.startJoinAddrs,
+		EncryptKey:          encryptKey,
+		Logger:              logger.With("node", cfg.nodeName),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, d.Leave, nil
+}
@@ -2,26 +2,35 @@ package main
 
 import (
 	"context"
-	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
-	"path"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/websocket"
-	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
-	"github.com/khatibomar/dhangkanna/internal/client"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
 	"github.com/khatibomar/dhangkanna/internal/game"
-	"google.golang.org/protobuf/types/known/emptypb"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+var tracer = otel.Tracer("github.com/khatibomar/dhangkanna/cmd/frontend")
+
 type Socket struct {
-	backendAddrs      []string
+	conn              *backendConn
+	limiter           *ratelimit.Limiter
 	upgrader          websocket.Upgrader
-	logger            *log.Logger
+	logger            *slog.Logger
 	sendChannel       chan Event
 	activeConnections map[*websocket.Conn]struct{}
 	mutex             sync.Mutex
@@ -32,17 +41,18 @@ type Event struct {
 	Content any    `json:"content"`
 }
 
-func NewSocket(ctx context.Context, backendAddrs []string) (*Socket, error) {
+func NewSocket(ctx context.Context, conn *backendConn, limiter *ratelimit.Limiter, logger *slog.Logger) (*Socket, error) {
 	n := &Socket{
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
-		logger:            log.New(log.Writer(), "Socket: ", log.LstdFlags|log.Lshortfile),
+		logger:            logging.Component(logger, "socket"),
 		sendChannel:       make(chan Event, 1),
 		activeConnections: make(map[*websocket.Conn]struct{}),
-		backendAddrs:      backendAddrs,
+		conn:              conn,
+		limiter:           limiter,
 	}
 
 	go n.sendMessages(ctx)
@@ -53,7 +63,7 @@ func NewSocket(ctx context.Context, backendAddrs []string) (*Socket, error) {
 func (n *Socket) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := n.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		n.logger.Printf("Error upgrading WebSocket connection: %v", err)
+		n.logger.Error("upgrading websocket connection", "error", err)
 		return
 	}
 
@@ -61,94 +71,129 @@ func (n *Socket) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	n.activeConnections[conn] = struct{}{}
 	n.mutex.Unlock()
 
-	n.logger.Printf("WebSocket connection established with %v", conn.RemoteAddr())
+	n.logger.Info("websocket connection established", "remote_addr", conn.RemoteAddr())
+	player := playerKey(r)
 
 	go func(client *websocket.Conn) {
 		defer func() {
 			if err := conn.Close(); err != nil {
-				n.logger.Printf("Error while closing connection with %v: %v", conn.RemoteAddr(), err)
+				n.logger.Error("closing connection", "remote_addr", conn.RemoteAddr(), "error", err)
 			}
 		}()
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		go n.receiveMessages(ctx, client)
+		go n.receiveMessages(ctx, client, player)
 
 		err := n.sendGameState(ctx)
 		if err != nil {
-			n.logger.Printf("failed to send state: %v", err)
+			n.logger.Error("failed to send state", "error", err)
 			return
 		}
 
 		<-ctx.Done()
-		n.logger.Printf("Connection with %v closed.", conn.RemoteAddr())
+		n.logger.Info("connection closed", "remote_addr", conn.RemoteAddr())
 	}(conn)
 
-	n.logger.Printf("WebSocket connection handler started for %v", conn.RemoteAddr())
+	n.logger.Debug("websocket connection handler started", "remote_addr", conn.RemoteAddr())
 }
 
-func (n *Socket) receiveMessages(ctx context.Context, client *websocket.Conn) {
+func (n *Socket) receiveMessages(ctx context.Context, client *websocket.Conn, player string) {
 	for {
 		select {
 		case <-ctx.Done():
-			n.logger.Printf("Stopped receiving messages from %v", client.RemoteAddr())
+			n.logger.Debug("stopped receiving messages", "remote_addr", client.RemoteAddr())
 			return
 		default:
-			var msg struct {
-				Letter  string `json:"letter"`
-				Restart bool   `json:"restart"`
-			}
+			var msg message
 
 			err := client.ReadJSON(&msg)
-			n.logger.Printf("received message %v from socket", msg)
 			if err != nil {
-				n.logger.Printf("Error reading JSON message from %v: %v", client.RemoteAddr(), err)
+				n.logger.Info("reading message", "remote_addr", client.RemoteAddr(), "error", err)
 				return
 			}
 
-			n.logger.Printf("Received message %v from %v", msg, client.RemoteAddr())
-
-			if msg.Restart {
-				err := n.resetGame(ctx)
-				if err != nil {
-					n.logger.Println(err)
-					return
-				}
-			} else {
-				letter := strings.ToLower(msg.Letter)
-				err := n.handleNewLetter(ctx, letter)
-				if err != nil {
-					n.logger.Println(err)
-					return
-				}
+			n.logger.Debug("received message", "remote_addr", client.RemoteAddr(), "letter", msg.Letter, "restart", msg.Restart)
+
+			// every message starts its own trace, it follows the guess
+			// through the backend down to the FSM of each server.
+			msgCtx, span := tracer.Start(ctx, "websocket.message",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("player", player),
+					attribute.String("letter", msg.Letter),
+					attribute.Bool("restart", msg.Restart),
+				),
+			)
+			err = n.handleMessage(msgCtx, client, player, msg)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(otelcodes.Error, err.Error())
 			}
+			span.End()
+			if err != nil {
+				n.logger.ErrorContext(msgCtx, "handling message", "remote_addr", client.RemoteAddr(), "error", err)
+				return
+			}
+		}
+	}
+}
+
+type message struct {
+	Letter  string `json:"letter"`
+	Restart bool   `json:"restart"`
+}
+
+// handleMessage only fails on errors that should close the connection,
+// rate limited players are notified instead.
+func (n *Socket) handleMessage(ctx context.Context, client *websocket.Conn, player string, msg message) error {
+	if n.limiter != nil {
+		if ok, retryAfter := n.limiter.Allow(player); !ok {
+			trace.SpanFromContext(ctx).AddEvent("rate limited")
+			n.notifyRateLimited(client, retryAfter)
+			return nil
 		}
 	}
+
+	// the backends limit players rather than the frontend as a whole.
+	ctx = ratelimit.WithPlayer(ctx, player)
+	var err error
+	if msg.Restart {
+		err = n.resetGame(ctx)
+	} else {
+		err = n.handleNewLetter(ctx, strings.ToLower(msg.Letter))
+	}
+	if retryAfter, ok := retryDelay(err); ok {
+		trace.SpanFromContext(ctx).AddEvent("rate limited by backend")
+		n.notifyRateLimited(client, retryAfter)
+		return nil
+	}
+	return err
 }
 
 func (n *Socket) sendMessages(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			n.logger.Printf("Stopped sending messages")
+			n.logger.Debug("stopped sending messages")
 			return
 
 		case e := <-n.sendChannel:
-			n.logger.Printf("Sending message to socket %+v\n", e)
+			n.logger.Debug("sending event to sockets", "event", e.Name)
 
 			n.mutex.Lock()
 			connectionsToDelete := make([]*websocket.Conn, 0)
 			for c := range n.activeConnections {
 				err := c.WriteJSON(e)
 				if err != nil {
-					n.logger.Printf("Error sending message to %v: %v", c.RemoteAddr(), err)
+					n.logger.Warn("sending event", "remote_addr", c.RemoteAddr(), "error", err)
 					connectionsToDelete = append(connectionsToDelete, c)
 				}
 			}
 			for _, c := range connectionsToDelete {
 				delete(n.activeConnections, c)
-				n.logger.Printf("Connection with %v removed from active connections", c.RemoteAddr())
+				n.logger.Debug("connection removed from active connections", "remote_addr", c.RemoteAddr())
 			}
 			n.mutex.Unlock()
 		}
@@ -156,18 +201,18 @@ func (n *Socket) sendMessages(ctx context.Context) {
 }
 
 func (n *Socket) handleNewLetter(ctx context.Context, letter string) error {
-	n.logger.Printf("Handling letter %v", letter)
+	n.logger.DebugContext(ctx, "handling letter", "letter", letter)
 
-	c, err := n.connectToRandomServer()
+	c, err := n.conn.client()
 	if err != nil {
 		return err
 	}
 
-	_, err = c.Send(ctx, &api.Letter{Letter: letter})
+	_, err = c.Guess(ctx, &dhangkannav1.GuessRequest{GameId: game.DefaultID, Letter: letter})
 	if err != nil {
 		return err
 	}
-	n.logger.Printf("Letter %v handled successfully", letter)
+	n.logger.DebugContext(ctx, "letter handled", "letter", letter)
 	err = n.sendGameState(ctx)
 	if err != nil {
 		return err
@@ -176,42 +221,79 @@ func (n *Socket) handleNewLetter(ctx context.Context, letter string) error {
 }
 
 func (n *Socket) sendGameState(ctx context.Context) error {
-	c, err := n.connectToRandomServer()
+	c, err := n.conn.client()
 	if err != nil {
 		return err
 	}
 
-	g, err := c.Receive(ctx, &emptypb.Empty{})
+	res, err := c.GetGame(ctx, &dhangkannav1.GetGameRequest{GameId: game.DefaultID})
 	if err != nil {
 		return err
 	}
 
-	n.sendSocketEvent(Event{Name: "game", Content: game.ConvertGameApiToGame(g)})
-	n.logger.Println("Sending game to all connected clients")
+	n.sendSocketEvent(Event{Name: "game", Content: game.ConvertGameV1ToGame(res.Game)})
+	n.logger.Debug("sending game to all connected clients")
 	return nil
 }
 
 func (n *Socket) sendNotification(message string) {
 	n.sendSocketEvent(Event{Name: "notification", Content: message})
-	n.logger.Printf("Sending notification: %v", message)
+	n.logger.Debug("sending notification", "message", message)
+}
+
+// notifyRateLimited only tells the player who got limited, unlike
+// sendNotification which goes to every connection.
+func (n *Socket) notifyRateLimited(client *websocket.Conn, retryAfter time.Duration) {
+	n.logger.Info("rate limited", "remote_addr", client.RemoteAddr(), "retry_after", retryAfter)
+	message := fmt.Sprintf("Slow down! try again in %s", retryAfter.Round(100*time.Millisecond))
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if err := client.WriteJSON(Event{Name: "notification", Content: message}); err != nil {
+		n.logger.Warn("sending notification", "remote_addr", client.RemoteAddr(), "error", err)
+	}
+}
+
+// retryDelay reports whether err is the backend rate limiting us and how
+// long it asked to wait.
+func retryDelay(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if err == nil || !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return time.Second, true
+}
+
+// playerKey identifies players by IP, the websocket has no notion of
+// accounts.
+func playerKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func (n *Socket) sendSocketEvent(event Event) {
-	n.logger.Printf("Sending message to channel %+v", event)
+	n.logger.Debug("queueing event", "event", event.Name)
 	n.sendChannel <- event
 }
 
 func (n *Socket) resetGame(ctx context.Context) error {
-	c, err := n.connectToRandomServer()
+	c, err := n.conn.client()
 	if err != nil {
 		return err
 	}
 
-	_, err = c.Reset(ctx, &emptypb.Empty{})
+	_, err = c.ResetGame(ctx, &dhangkannav1.ResetGameRequest{GameId: game.DefaultID})
 	if err != nil {
 		return err
 	}
-	n.logger.Println("DistributedGame has been reset.")
+	n.logger.InfoContext(ctx, "game reset")
 
 	err = n.sendGameState(ctx)
 	if err != nil {
@@ -220,58 +302,3 @@ func (n *Socket) resetGame(ctx context.Context) error {
 
 	return nil
 }
-
-func (n *Socket) connectToRandomServer() (api.GameServiceClient, error) {
-	var servers []string
-	var err error
-	if len(n.backendAddrs) > 0 {
-		servers = n.backendAddrs
-	} else {
-		servers, err = getAllServerAddresses()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	for _, s := range servers {
-		c, err := client.New(s)
-		if err != nil {
-			continue
-		}
-
-		return c, nil
-	}
-
-	return nil, errors.New("no backend servers found")
-}
-
-func getAllServerAddresses() ([]string, error) {
-	dir := path.Join(os.TempDir(), "dhangkanna", "serverlist.db")
-	db, err := bolt.Open(dir, 0600, nil)
-	if err != nil {
-		return make([]string, 0), err
-	}
-	defer func(db *bolt.DB) {
-		err := db.Close()
-		if err != nil {
-			log.Println(err)
-		}
-	}(db)
-
-	var addresses []string
-
-	err = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("ServerAddresses"))
-		if bucket == nil {
-			return nil
-		}
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			log.Printf("Found server with key/value - %s : %s\n", k, v)
-			addresses = append(addresses, string(k))
-		}
-		return nil
-	})
-
-	return addresses, err
-}
@@ -2,17 +2,26 @@ package agent
 
 import (
 	"bytes"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"github.com/hashicorp/raft"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/config"
 	"github.com/khatibomar/dhangkanna/internal/discovery"
 	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/metrics"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
 	"github.com/khatibomar/dhangkanna/internal/server"
 	"github.com/soheilhy/cmux"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -22,8 +31,10 @@ type Agent struct {
 	mux             cmux.CMux
 	DistributedGame *game.DistributedGame
 	server          *grpc.Server
+	serverTLSConfig *tls.Config
+	peerTLSConfig   *tls.Config
 	discovery       *discovery.Discovery
-	logger          *log.Logger
+	logger          *slog.Logger
 	shutdown        bool
 	shutdowns       chan struct{}
 	shutdownLock    sync.Mutex
@@ -36,6 +47,72 @@ type Config struct {
 	StartJoinAddrs []string
 	Bootstrap      bool
 	DataDir        string
+	// Role is either discovery.RoleVoter or discovery.RoleNonvoter,
+	// empty means voter.
+	Role string
+	// SnapshotInterval, SnapshotThreshold and SnapshotRetain tune raft
+	// snapshots, zero values keep raft defaults.
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+	SnapshotRetain    int
+	// RecoverPeersPath points to a peers.json file used to recover a
+	// cluster that lost its quorum, leave empty for normal starts.
+	RecoverPeersPath string
+	// TLSCAFile, TLSCertFile and TLSKeyFile enable mutual TLS between
+	// raft peers and for gRPC clients, either all of them are set or none.
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	// GossipKey is the base64 encoded key used to encrypt serf gossip,
+	// GossipKeyringFile persists the keyring across restarts and key
+	// rotations.
+	GossipKey         string
+	GossipKeyringFile string
+	// AuthTokensFile lists static bearer tokens and AuthSecretFile holds
+	// the HMAC secret of signed tokens, setting either enforces auth.
+	AuthTokensFile string
+	AuthSecretFile string
+	// GuessRateLimit is how many guesses per second a client can send on
+	// average, with bursts of GuessRateBurst. Zero disables the limit.
+	GuessRateLimit float64
+	GuessRateBurst int
+	// ReadyMaxLag is how many committed raft entries a node can have left
+	// to apply and still report itself ready.
+	ReadyMaxLag uint64
+	// Reflection serves gRPC reflection on the RPC port.
+	Reflection bool
+	// Logger is shared by every component of the agent, tagged with the
+	// node name. Nil uses slog.Default.
+	Logger *slog.Logger
+}
+
+func (c Config) TLSEnabled() bool {
+	return c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
+// PeerTLSConfigs builds the TLS configs used to accept and dial peers.
+func (c Config) PeerTLSConfigs() (serverTLSConfig, peerTLSConfig *tls.Config, err error) {
+	if c.TLSCAFile == "" || c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return nil, nil, fmt.Errorf("TLS needs a CA file, a cert file and a key file")
+	}
+	serverTLSConfig, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile: c.TLSCertFile,
+		KeyFile:  c.TLSKeyFile,
+		CAFile:   c.TLSCAFile,
+		Server:   true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	peerTLSConfig, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile: c.TLSCertFile,
+		KeyFile:  c.TLSKeyFile,
+		CAFile:   c.TLSCAFile,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return serverTLSConfig, peerTLSConfig, nil
 }
 
 func (c Config) RPCAddr() (string, error) {
@@ -47,16 +124,31 @@ func (c Config) RPCAddr() (string, error) {
 }
 
 func New(config Config) (*Agent, error) {
+	if config.Role == "" {
+		config.Role = discovery.RoleVoter
+	}
+	if config.Role != discovery.RoleVoter && config.Role != discovery.RoleNonvoter {
+		return nil, fmt.Errorf("unknown role %q", config.Role)
+	}
+	if config.Bootstrap && config.Role == discovery.RoleNonvoter {
+		return nil, fmt.Errorf("a nonvoter can't bootstrap the cluster")
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	config.Logger = config.Logger.With("node", config.NodeName)
 	a := &Agent{
 		Config:    config,
 		shutdowns: make(chan struct{}),
-		logger:    log.New(os.Stdout, "agent: ", log.LstdFlags|log.Lshortfile),
+		logger:    logging.Component(config.Logger, "agent"),
 	}
 	setup := []func() error{
+		a.setupTLS,
 		a.setupMux,
 		a.setupGame,
-		a.setupServer,
 		a.setupDiscovery,
+		a.setupMetrics,
+		a.setupServer,
 	}
 	for _, fn := range setup {
 		if err := fn(); err != nil {
@@ -66,7 +158,7 @@ func New(config Config) (*Agent, error) {
 
 	go func() {
 		if err := a.serve(); err != nil {
-			a.logger.Printf("error while serving mux : %v", err)
+			a.logger.Error("serving mux", "error", err)
 		}
 	}()
 
@@ -81,6 +173,15 @@ func (a *Agent) serve() error {
 	return nil
 }
 
+func (a *Agent) setupTLS() error {
+	if !a.Config.TLSEnabled() {
+		return nil
+	}
+	var err error
+	a.serverTLSConfig, a.peerTLSConfig, err = a.Config.PeerTLSConfigs()
+	return err
+}
+
 func (a *Agent) setupMux() error {
 	addr, err := net.ResolveTCPAddr("tcp", a.Config.BindAddr)
 	if err != nil {
@@ -110,6 +211,8 @@ func (a *Agent) setupGame() error {
 	gameConfig := game.Config{}
 	gameConfig.Raft.StreamLayer = game.NewStreamLayer(
 		raftLn,
+		a.serverTLSConfig,
+		a.peerTLSConfig,
 	)
 	rpcAddr, err := a.Config.RPCAddr()
 	if err != nil {
@@ -118,6 +221,12 @@ func (a *Agent) setupGame() error {
 	gameConfig.Raft.BindAddr = rpcAddr
 	gameConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
 	gameConfig.Raft.Bootstrap = a.Config.Bootstrap
+	gameConfig.Raft.SnapshotInterval = a.Config.SnapshotInterval
+	gameConfig.Raft.SnapshotThreshold = a.Config.SnapshotThreshold
+	gameConfig.Raft.SnapshotRetain = a.Config.SnapshotRetain
+	gameConfig.Raft.RecoverPeers = a.Config.RecoverPeersPath
+	gameConfig.ReadyMaxLag = a.Config.ReadyMaxLag
+	gameConfig.Logger = a.Config.Logger
 	a.DistributedGame, err = game.NewDistributedGame(
 		a.Config.DataDir,
 		gameConfig,
@@ -132,33 +241,76 @@ func (a *Agent) setupGame() error {
 }
 
 func (a *Agent) setupDiscovery() error {
-	a.logger.Println("setting up discovery")
+	a.logger.Debug("setting up discovery")
 	rpcAddr, err := a.Config.RPCAddr()
 	if err != nil {
-		a.logger.Printf("Error getting RPC address: %v", err)
 		return err
 	}
-	a.logger.Println(a.Config.StartJoinAddrs)
+	a.logger.Debug("joining", "start_join_addrs", a.Config.StartJoinAddrs)
+	var encryptKey []byte
+	if a.Config.GossipKey != "" {
+		encryptKey, err = base64.StdEncoding.DecodeString(a.Config.GossipKey)
+		if err != nil {
+			return fmt.Errorf("decoding gossip key: %w", err)
+		}
+	}
+	keyringFile := a.Config.GossipKeyringFile
+	if keyringFile == "" && encryptKey != nil {
+		keyringFile = filepath.Join(a.Config.DataDir, "serf", "keyring")
+		if err := os.MkdirAll(filepath.Dir(keyringFile), 0755); err != nil {
+			return err
+		}
+	}
 	a.discovery, err = discovery.New(a.DistributedGame, discovery.Config{
 		NodeName: a.Config.NodeName,
 		BindAddr: a.Config.BindAddr,
 		Tags: map[string]string{
 			"rpc_addr": rpcAddr,
+			"role":     a.Config.Role,
 		},
 		StartJoinsAddresses: a.Config.StartJoinAddrs,
+		EncryptKey:          encryptKey,
+		KeyringFile:         keyringFile,
+		Logger:              a.Config.Logger,
 	})
-	a.logger.Println("done setting up discovery")
+	a.logger.Debug("done setting up discovery")
 	return err
 }
 
+func (a *Agent) setupMetrics() error {
+	if err := metrics.Setup(); err != nil {
+		return err
+	}
+	if err := metrics.Register(metrics.NewRaftCollector(a.DistributedGame.Raft)); err != nil {
+		return err
+	}
+	return metrics.Register(metrics.NewSerfCollector(a.discovery.Members))
+}
+
 func (a *Agent) setupServer() error {
-	a.logger.Println("setting up server")
+	a.logger.Debug("setting up server")
 	serverConfig := &server.Config{
-		Game:        a.DistributedGame,
-		GetServerer: a.DistributedGame,
+		Game:          a.DistributedGame,
+		GetServerer:   a.DistributedGame,
+		ServerWatcher: a.DistributedGame,
+		Administrator: a.DistributedGame,
+		Keyring:       a.discovery,
+		HealthChecker: a.DistributedGame,
+		Reflection:    a.Config.Reflection,
+		Logger:        a.Config.Logger,
+	}
+	authenticator, err := a.setupAuth()
+	if err != nil {
+		return err
+	}
+	serverConfig.Authenticator = authenticator
+	if a.Config.GuessRateLimit > 0 {
+		serverConfig.GuessLimiter = ratelimit.New(a.Config.GuessRateLimit, a.Config.GuessRateBurst)
 	}
 	var opts []grpc.ServerOption
-	var err error
+	if a.serverTLSConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(a.serverTLSConfig)))
+	}
 	a.server, err = server.NewGRPCServer(serverConfig, opts...)
 	if err != nil {
 		return err
@@ -169,10 +321,36 @@ func (a *Agent) setupServer() error {
 			_ = a.Shutdown()
 		}
 	}()
-	a.logger.Println("done setting up server")
+	a.logger.Debug("done setting up server")
 	return err
 }
 
+func (a *Agent) setupAuth() (auth.Authenticator, error) {
+	var authenticators auth.Authenticators
+	if a.Config.AuthTokensFile != "" {
+		tokens, err := auth.LoadStaticTokens(a.Config.AuthTokensFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if a.Config.AuthSecretFile != "" {
+		secret, err := os.ReadFile(a.Config.AuthSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("auth secret file %s is empty", a.Config.AuthSecretFile)
+		}
+		authenticators = append(authenticators, auth.NewSigner(secret))
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return authenticators, nil
+}
+
 func (a *Agent) Shutdown() error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
@@ -191,10 +369,10 @@ func (a *Agent) Shutdown() error {
 	}
 	for _, fn := range shutdown {
 		if err := fn(); err != nil {
-			a.logger.Printf("Error during shutdown: %v", err)
+			a.logger.Error("shutting down", "error", err)
 			return err
 		}
 	}
-	a.logger.Println("Shutting down...")
+	a.logger.Info("shut down")
 	return nil
 }
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/status"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Status gathers what the node knows about the cluster, every part is
+// read under the lock of its owner.
+func (a *Agent) Status() *status.Status {
+	st := &status.Status{
+		Node:    a.Config.NodeName,
+		Raft:    a.raftStatus(),
+		Members: make([]status.Member, 0),
+		Games: []status.Game{{
+			ID:   game.DefaultID,
+			Game: a.DistributedGame.Copy(),
+		}},
+		Time: time.Now(),
+	}
+	if err := a.DistributedGame.Live(); err != nil {
+		st.Live = err.Error()
+	}
+	if err := a.DistributedGame.Ready(); err != nil {
+		st.Ready = err.Error()
+	}
+	for _, member := range a.discovery.Members() {
+		st.Members = append(st.Members, status.Member{
+			Name:   member.Name,
+			Addr:   net.JoinHostPort(member.Addr.String(), strconv.Itoa(int(member.Port))),
+			Status: member.Status.String(),
+			Tags:   member.Tags,
+		})
+	}
+	return st
+}
+
+func (a *Agent) raftStatus() status.Raft {
+	r := a.DistributedGame.Raft
+	stats := r.Stats()
+	parse := func(key string) uint64 {
+		v, _ := strconv.ParseUint(stats[key], 10, 64)
+		return v
+	}
+	leaderAddr, leaderID := r.LeaderWithID()
+	st := status.Raft{
+		State:        r.State().String(),
+		Term:         parse("term"),
+		LastLogIndex: parse("last_log_index"),
+		CommitIndex:  parse("commit_index"),
+		AppliedIndex: r.AppliedIndex(),
+		LastContact:  stats["last_contact"],
+		LeaderID:     string(leaderID),
+		LeaderAddr:   string(leaderAddr),
+		Servers:      make([]status.RaftServer, 0),
+	}
+	future := r.GetConfiguration()
+	if err := future.Error(); err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	for _, srv := range future.Configuration().Servers {
+		st.Servers = append(st.Servers, status.RaftServer{
+			ID:       string(srv.ID),
+			Address:  string(srv.Address),
+			Suffrage: srv.Suffrage.String(),
+			Leader:   srv.ID == leaderID,
+		})
+	}
+	return st
+}
@@ -0,0 +1,142 @@
+// Package auth authenticates bearer tokens and maps them to roles.
+//
+// Two kinds of tokens are supported, static tokens listed in a file and
+// signed tokens minted with a shared HMAC secret, see Signer.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type Role int
+
+const (
+	RolePlayer Role = iota + 1
+	RoleModerator
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RolePlayer:    "player",
+	RoleModerator: "moderator",
+	RoleAdmin:     "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if name == s {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q", s)
+}
+
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Role) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	role, err := ParseRole(s)
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// Identity is who a token belongs to and what it is allowed to do, roles
+// are ordered so an admin can do everything a moderator can.
+type Identity struct {
+	Subject string `json:"sub"`
+	Role    Role   `json:"role"`
+}
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
+
+// Authenticators tries each Authenticator in order.
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(token string) (Identity, error) {
+	for _, authenticator := range a {
+		id, err := authenticator.Authenticate(token)
+		if err == nil {
+			return id, nil
+		}
+	}
+	return Identity{}, ErrInvalidToken
+}
+
+// StaticTokens authenticates tokens listed in a file, tokens are kept
+// hashed so looking them up doesn't leak timing information.
+type StaticTokens struct {
+	tokens map[[sha256.Size]byte]Identity
+}
+
+type staticToken struct {
+	Token   string `json:"token"`
+	Subject string `json:"subject"`
+	Role    Role   `json:"role"`
+}
+
+// LoadStaticTokens reads a JSON file shaped like
+//
+//	[{"token": "s3cr3t", "subject": "alice", "role": "admin"}]
+func LoadStaticTokens(path string) (*StaticTokens, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []staticToken
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("reading tokens file: %w", err)
+	}
+	s := &StaticTokens{tokens: make(map[[sha256.Size]byte]Identity, len(entries))}
+	for _, e := range entries {
+		if e.Token == "" {
+			return nil, fmt.Errorf("token of %q is empty", e.Subject)
+		}
+		s.tokens[sha256.Sum256([]byte(e.Token))] = Identity{Subject: e.Subject, Role: e.Role}
+	}
+	return s, nil
+}
+
+func (s *StaticTokens) Authenticate(token string) (Identity, error) {
+	id, ok := s.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	return id, nil
+}
+
+type identityKey struct{}
+
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, ok is false when the
+// server doesn't authenticate calls.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
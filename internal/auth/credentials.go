@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc/credentials"
+)
+
+type bearerToken struct {
+	token      string
+	requireTLS bool
+}
+
+// BearerToken attaches token to every RPC, requireTLS refuses to send it
+// over plaintext connections.
+func BearerToken(token string, requireTLS bool) credentials.PerRPCCredentials {
+	return bearerToken{token: token, requireTLS: requireTLS}
+}
+
+func (b bearerToken) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token := b.token
+	if t, ok := ctx.Value(tokenKey{}).(string); ok {
+		token = t
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.requireTLS
+}
+
+type tokenKey struct{}
+
+// WithToken sends token instead of the one of BearerToken on the calls made
+// with ctx, so a frontend calls on behalf of its caller and the servers
+// check the role of the caller rather than its own.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
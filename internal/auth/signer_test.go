@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	signer := &Signer{secret: []byte("secret"), now: clock}
+	other := &Signer{secret: []byte("other secret"), now: clock}
+	id := Identity{Subject: "frontend", Role: RoleModerator}
+
+	sign := func(t *testing.T, ttl time.Duration) string {
+		t.Helper()
+		token, err := signer.Sign(id, ttl)
+		if err != nil {
+			t.Fatalf("signing: %v", err)
+		}
+		return token
+	}
+	// tamper flips the last character of the payload or the signature.
+	tamper := func(token string, part int) string {
+		parts := strings.Split(token, ".")
+		b := []byte(parts[part])
+		if b[len(b)-1] == 'A' {
+			b[len(b)-1] = 'B'
+		} else {
+			b[len(b)-1] = 'A'
+		}
+		parts[part] = string(b)
+		return strings.Join(parts, ".")
+	}
+	// forge re-encodes the payload with a raised role, keeping the
+	// signature of the original one.
+	forge := func(token string) string {
+		_, sig, _ := strings.Cut(token, ".")
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"frontend","role":"admin"}`))
+		return payload + "." + sig
+	}
+
+	tests := []struct {
+		name     string
+		token    func(t *testing.T) string
+		verifier *Signer
+		advance  time.Duration
+		wantErr  bool
+	}{
+		{name: "valid without expiry", token: func(t *testing.T) string { return sign(t, 0) }, verifier: signer, advance: 24 * 365 * time.Hour},
+		{name: "valid before expiry", token: func(t *testing.T) string { return sign(t, time.Hour) }, verifier: signer, advance: time.Hour - time.Second},
+		{name: "expired", token: func(t *testing.T) string { return sign(t, time.Hour) }, verifier: signer, advance: time.Hour, wantErr: true},
+		{name: "wrong secret", token: func(t *testing.T) string { return sign(t, 0) }, verifier: other, wantErr: true},
+		{name: "tampered signature", token: func(t *testing.T) string { return tamper(sign(t, 0), 1) }, verifier: signer, wantErr: true},
+		{name: "tampered payload", token: func(t *testing.T) string { return tamper(sign(t, 0), 0) }, verifier: signer, wantErr: true},
+		{name: "forged role", token: func(t *testing.T) string { return forge(sign(t, 0)) }, verifier: signer, wantErr: true},
+		{name: "no signature", token: func(t *testing.T) string { before, _, _ := strings.Cut(sign(t, 0), "."); return before }, verifier: signer, wantErr: true},
+		{name: "empty", token: func(*testing.T) string { return "" }, verifier: signer, wantErr: true},
+		{name: "not base64", token: func(*testing.T) string { return "!!.!!" }, verifier: signer, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Unix(1_700_000_000, 0)
+			token := tt.token(t)
+			now = now.Add(tt.advance)
+			got, err := tt.verifier.Authenticate(token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("got %+v, %v, want ErrInvalidToken", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authenticating: %v", err)
+			}
+			if got != id {
+				t.Fatalf("got %+v, want %+v", got, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package backup reads and writes portable cluster backups.
+//
+// A backup is a gzipped tar archive holding two entries, meta.json with
+// the raft snapshot metadata and state.bin with the raw FSM snapshot, so it
+// can be inspected with standard tools.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	metaFile  = "meta.json"
+	stateFile = "state.bin"
+)
+
+type Meta struct {
+	ID        string    `json:"id"`
+	Index     uint64    `json:"index"`
+	Term      uint64    `json:"term"`
+	Size      int64     `json:"size"`
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Write writes meta followed by exactly meta.Size bytes read from state.
+func Write(w io.Writer, meta Meta, state io.Reader) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	m, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    metaFile,
+		Mode:    0600,
+		Size:    int64(len(m)),
+		ModTime: meta.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(m); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    stateFile,
+		Mode:    0600,
+		Size:    meta.Size,
+		ModTime: meta.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, state, meta.Size); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Read reads the meta of a backup and returns a reader over its state.
+func Read(r io.Reader) (Meta, io.Reader, error) {
+	var meta Meta
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return meta, nil, err
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return meta, nil, err
+	}
+	if hdr.Name != metaFile {
+		return meta, nil, fmt.Errorf("expected %s, got %s", metaFile, hdr.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+		return meta, nil, err
+	}
+
+	hdr, err = tr.Next()
+	if err != nil {
+		return meta, nil, err
+	}
+	if hdr.Name != stateFile {
+		return meta, nil, fmt.Errorf("expected %s, got %s", stateFile, hdr.Name)
+	}
+	if hdr.Size != meta.Size {
+		return meta, nil, errors.New("state size doesn't match the meta")
+	}
+	return meta, tr, nil
+}
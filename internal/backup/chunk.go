@@ -0,0 +1,30 @@
+package backup
+
+import "io"
+
+// ChunkReader turns a stream of snapshot chunks into an io.Reader, it
+// starts with the data of the first chunk, already received along with
+// the meta, and then calls next for the following ones until it fails.
+type ChunkReader struct {
+	next func() ([]byte, error)
+	buf  []byte
+}
+
+func NewChunkReader(first []byte, next func() ([]byte, error)) *ChunkReader {
+	return &ChunkReader{next: next, buf: first}
+}
+
+func (r *ChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+var _ io.Reader = (*ChunkReader)(nil)
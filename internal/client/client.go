@@ -1,25 +1,86 @@
 package client
 
 import (
+	"crypto/tls"
 	"fmt"
-	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
 	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"log/slog"
+	"time"
 )
 
-func New(rpcAddr string) (api.GameServiceClient, error) {
+type Config struct {
+	// TLSConfig enables TLS, set its certificates when the servers verify
+	// client certificates. Nil means plaintext.
+	TLSConfig *tls.Config
+	// Token is sent as a bearer token on every call when set.
+	Token string
+	// Logger receives the resolver logs, nil uses slog.Default.
+	Logger *slog.Logger
+
+	// The fields below are only used by Client.
+
+	// Consistency of the reads, Eventual by default.
+	Consistency loadbalance.Consistency
+	// Timeout bounds every attempt of a write and every read, defaults to
+	// 5 seconds.
+	Timeout time.Duration
+	// MaxAttempts is how many times a write is tried, defaults to 5. The
+	// reads are retried by the service config of the connection.
+	MaxAttempts int
+	// RetryBackoff is the wait before the first retry, it doubles after
+	// every attempt. Defaults to 100 milliseconds.
+	RetryBackoff time.Duration
+	// WatchInterval is how often Watch polls the game, defaults to 1 second.
+	WatchInterval time.Duration
+}
+
+// connectParams space out the reconnections to a server that went away,
+// up to 10 seconds apart.
+var connectParams = grpc.ConnectParams{
+	Backoff: backoff.Config{
+		BaseDelay:  500 * time.Millisecond,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   10 * time.Second,
+	},
+	MinConnectTimeout: 5 * time.Second,
+}
+
+// Dial connects to the cluster through rpcAddr, the load balancer then
+// follows the servers it lists. The connection is meant to be long-lived,
+// it reconnects on its own and has to be closed by the caller.
+func Dial(rpcAddr string, config Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if config.TLSConfig != nil {
+		creds = credentials.NewTLS(config.TLSConfig)
+	}
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithConnectParams(connectParams),
 	}
-	conn, err := grpc.Dial(fmt.Sprintf(
+	var resolverOpts []grpc.DialOption
+	if config.Token != "" {
+		tokenOpt := grpc.WithPerRPCCredentials(auth.BearerToken(config.Token, config.TLSConfig != nil))
+		opts = append(opts, tokenOpt)
+		// the resolver needs the token as well to list the servers.
+		resolverOpts = append(resolverOpts, tokenOpt)
+	}
+	// every connection gets its own resolver, the registered one keeps the
+	// state of the last connection built with it.
+	opts = append(opts, grpc.WithResolvers(&loadbalance.Resolver{
+		DialOptions: resolverOpts,
+		Logger:      config.Logger,
+	}))
+	return grpc.Dial(fmt.Sprintf(
 		"%s:///%s",
 		loadbalance.Name,
 		rpcAddr,
 	), opts...)
-	if err != nil {
-		return nil, err
-	}
-	client := api.NewGameServiceClient(conn)
-	return client, nil
 }
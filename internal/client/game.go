@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+var ErrUnknownGame = errors.New("unknown game")
+
+// Client plays the games of a cluster without going through the proto
+// types. Calls reaching a follower or a server going away are retried, the
+// writes by the client and the reads by the service config of the
+// connection, so the callers only see errors the cluster can't recover
+// from on its own.
+type Client struct {
+	conn   *grpc.ClientConn
+	game   dhangkannav1.GameServiceClient
+	config Config
+}
+
+// New connects to the cluster through rpcAddr, the client has to be closed
+// once done.
+func New(rpcAddr string, config Config) (*Client, error) {
+	if config.Consistency == "" {
+		config.Consistency = loadbalance.Eventual
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 5
+	}
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.WatchInterval == 0 {
+		config.WatchInterval = time.Second
+	}
+	conn, err := Dial(rpcAddr, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:   conn,
+		game:   dhangkannav1.NewGameServiceClient(conn),
+		config: config,
+	}, nil
+}
+
+// Guess plays letter, a letter guessed twice doesn't cost a chance which
+// makes guesses safe to retry.
+func (c *Client) Guess(ctx context.Context, gameID, letter string) error {
+	if err := checkGame(gameID); err != nil {
+		return err
+	}
+	return c.call(ctx, func(ctx context.Context) error {
+		_, err := c.game.Guess(ctx, &dhangkannav1.GuessRequest{GameId: gameID, Letter: letter})
+		return err
+	})
+}
+
+// State reads the game with the consistency of the client, or the one set
+// on ctx.
+func (c *Client) State(ctx context.Context, gameID string) (game.Game, error) {
+	if err := checkGame(gameID); err != nil {
+		return game.Game{}, err
+	}
+	// a single attempt, the service config of the connection retries the
+	// reads already.
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	res, err := c.game.GetGame(c.withConsistency(ctx), &dhangkannav1.GetGameRequest{GameId: gameID})
+	if err != nil {
+		return game.Game{}, err
+	}
+	return game.ConvertGameV1ToGame(res.Game), nil
+}
+
+// withConsistency applies the consistency of the client, unless the caller
+// already picked one for this call with loadbalance.WithConsistency.
+func (c *Client) withConsistency(ctx context.Context) context.Context {
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(loadbalance.ConsistencyKey)) > 0 {
+		return ctx
+	}
+	return loadbalance.WithConsistency(ctx, c.config.Consistency)
+}
+
+// Watch calls fn with the current state of the game and then with every
+// new version, until ctx is done or fn fails. The game is polled, so fn
+// can miss versions applied in between.
+func (c *Client) Watch(ctx context.Context, gameID string, fn func(game.Game) error) error {
+	ticker := time.NewTicker(c.config.WatchInterval)
+	defer ticker.Stop()
+	version := -1
+	for {
+		g, err := c.State(ctx, gameID)
+		if err != nil {
+			return err
+		}
+		if g.Version != version {
+			version = g.Version
+			if err := fn(g); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reset starts the game over, it needs the moderator role when the cluster
+// enforces authorization.
+func (c *Client) Reset(ctx context.Context, gameID string) error {
+	if err := checkGame(gameID); err != nil {
+		return err
+	}
+	return c.call(ctx, func(ctx context.Context) error {
+		_, err := c.game.ResetGame(ctx, &dhangkannav1.ResetGameRequest{GameId: gameID})
+		return err
+	})
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// call runs the write fn until it succeeds, fails with an error retrying won't fix
+// or runs out of attempts. Every attempt gets its own deadline within the
+// one of ctx.
+func (c *Client) call(ctx context.Context, fn func(context.Context) error) error {
+	backoff := c.config.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+		err := fn(attemptCtx)
+		cancel()
+		if err == nil || attempt >= c.config.MaxAttempts || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable is true for calls that reached a follower while the picker
+// catches up with a new leader, and for servers that went away. The picker
+// resolves the servers again on both.
+func retryable(err error) bool {
+	if loadbalance.IsNotLeader(err) {
+		return true
+	}
+	return status.Code(err) == codes.Unavailable
+}
+
+// checkGame rejects the games the cluster doesn't have, it runs a single
+// one for now.
+func checkGame(gameID string) error {
+	if gameID != game.DefaultID {
+		return ErrUnknownGame
+	}
+	return nil
+}
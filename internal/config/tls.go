@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// ServerAddress is the name the peer certificate is verified against,
+	// only used by clients.
+	ServerAddress string
+	// Server builds a config that requires and verifies client certificates.
+	Server bool
+}
+
+// SetupTLSConfig builds a mutual TLS config, both sides present a certificate
+// signed by the CA in CAFile and verify the other side against it.
+func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ca := x509.NewCertPool()
+		if !ca.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("failed to parse root certificate: %q", cfg.CAFile)
+		}
+		if cfg.Server {
+			tlsConfig.ClientCAs = ca
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.RootCAs = ca
+		}
+		tlsConfig.ServerName = cfg.ServerAddress
+	}
+	return tlsConfig, nil
+}
@@ -1,18 +1,36 @@
 package discovery
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/raft"
 	"github.com/hashicorp/serf/serf"
-	"log"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"log/slog"
 	"net"
 	"os"
 )
 
+const (
+	// RoleVoter members take part in raft elections and commits.
+	RoleVoter = "voter"
+	// RoleNonvoter members only replicate the log and serve reads.
+	RoleNonvoter = "nonvoter"
+	// RoleFrontend members only watch the membership to find servers,
+	// they are never part of raft.
+	RoleFrontend = "frontend"
+)
+
 type Discovery struct {
 	Config
 	handler Handler
 	serf    *serf.Serf
 	events  chan serf.Event
-	logger  *log.Logger
+	logger  *slog.Logger
 }
 
 type Config struct {
@@ -20,10 +38,18 @@ type Config struct {
 	BindAddr            string
 	Tags                map[string]string
 	StartJoinsAddresses []string
+	// EncryptKey enables gossip encryption, members without the key are
+	// rejected. It is ignored when KeyringFile already holds keys.
+	EncryptKey []byte
+	// KeyringFile persists the keyring so rotated keys survive restarts.
+	KeyringFile string
+	// Logger receives discovery, serf and memberlist logs, nil uses
+	// slog.Default.
+	Logger *slog.Logger
 }
 
 type Handler interface {
-	Join(name, addr string) error
+	Join(name, addr string, voter bool) error
 	Leave(name string) error
 }
 
@@ -31,7 +57,7 @@ func New(handler Handler, config Config) (*Discovery, error) {
 	d := &Discovery{
 		Config:  config,
 		handler: handler,
-		logger:  log.New(os.Stdout, "discovery: ", log.LstdFlags|log.Lshortfile),
+		logger:  logging.Component(config.Logger, "discovery"),
 	}
 
 	if err := d.setup(); err != nil {
@@ -55,6 +81,14 @@ func (d *Discovery) setup() error {
 	config.EventCh = d.events
 	config.Tags = d.Config.Tags
 	config.NodeName = d.Config.NodeName
+	// serf and memberlist refuse a Logger when LogOutput is set as well.
+	config.LogOutput = nil
+	config.Logger = logging.StdLogger(logging.Component(d.Config.Logger, "serf"))
+	config.MemberlistConfig.LogOutput = nil
+	config.MemberlistConfig.Logger = logging.StdLogger(logging.Component(d.Config.Logger, "memberlist"))
+	if err := d.setupKeyring(config); err != nil {
+		return err
+	}
 
 	d.serf, err = serf.Create(config)
 	if err != nil {
@@ -72,13 +106,65 @@ func (d *Discovery) setup() error {
 	return nil
 }
 
+// setupKeyring loads the keyring from KeyringFile if it exists, otherwise it
+// starts from EncryptKey and writes it to KeyringFile.
+func (d *Discovery) setupKeyring(config *serf.Config) error {
+	config.KeyringFile = d.KeyringFile
+	var keys [][]byte
+	if d.KeyringFile != "" {
+		b, err := os.ReadFile(d.KeyringFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			var encoded []string
+			if err := json.Unmarshal(b, &encoded); err != nil {
+				return fmt.Errorf("reading keyring file: %w", err)
+			}
+			for _, e := range encoded {
+				key, err := base64.StdEncoding.DecodeString(e)
+				if err != nil {
+					return fmt.Errorf("reading keyring file: %w", err)
+				}
+				keys = append(keys, key)
+			}
+		}
+	}
+	writeFile := false
+	if len(keys) == 0 && d.EncryptKey != nil {
+		keys = [][]byte{d.EncryptKey}
+		writeFile = d.KeyringFile != ""
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// the first key is the primary one, serf writes it first as well.
+	keyring, err := memberlist.NewKeyring(keys, keys[0])
+	if err != nil {
+		return err
+	}
+	config.MemberlistConfig.Keyring = keyring
+	if writeFile {
+		b, err := json.MarshalIndent([]string{base64.StdEncoding.EncodeToString(keys[0])}, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(d.KeyringFile, b, 0600); err != nil {
+			return err
+		}
+	}
+	d.logger.Info("gossip encryption enabled", "keys", len(keys))
+	return nil
+}
+
 func (d *Discovery) handleSerfEvents() {
 	for e := range d.events {
-		d.logger.Printf("received serf event : %+v", e.EventType())
+		d.logger.Debug("received serf event", "event", e.EventType().String())
 		switch e.EventType() {
 		case serf.EventMemberJoin:
 			for _, member := range e.(serf.MemberEvent).Members {
-				if d.isLocal(member) {
+				if d.isLocal(member) || !isServer(member) {
 					continue
 				}
 				d.handleJoin(member)
@@ -86,6 +172,9 @@ func (d *Discovery) handleSerfEvents() {
 			break
 		case serf.EventMemberLeave, serf.EventMemberFailed:
 			for _, member := range e.(serf.MemberEvent).Members {
+				if !isServer(member) {
+					continue
+				}
 				d.handleLeave(member)
 			}
 			break
@@ -94,10 +183,11 @@ func (d *Discovery) handleSerfEvents() {
 }
 
 func (d *Discovery) handleJoin(member serf.Member) {
-	if err := d.handler.Join(member.Name, member.Tags["rpc_addr"]); err != nil {
+	voter := isVoter(member)
+	if err := d.handler.Join(member.Name, member.Tags["rpc_addr"], voter); err != nil {
 		d.logError(err, "failed to join", member)
 	} else {
-		d.logger.Printf("Joined: Name=%s, RPC Address=%s", member.Name, member.Tags["rpc_addr"])
+		d.logger.Info("member joined", "name", member.Name, "rpc_addr", member.Tags["rpc_addr"], "voter", voter)
 	}
 }
 
@@ -107,7 +197,24 @@ func (d *Discovery) handleLeave(member serf.Member) {
 		return
 	}
 
-	d.logger.Printf("Left: Name=%s, RPC Address=%s", member.Name, member.Tags["rpc_addr"])
+	d.logger.Info("member left", "name", member.Name, "rpc_addr", member.Tags["rpc_addr"])
+}
+
+// isServer tells members running raft apart from the ones only watching
+// the cluster, members without a role tag predate roles and are servers.
+func isServer(member serf.Member) bool {
+	switch member.Tags["role"] {
+	case "", RoleVoter, RoleNonvoter:
+		return true
+	default:
+		return false
+	}
+}
+
+// isVoter treats members without a role tag as voters, they were started
+// before roles existed and always joined as voters.
+func isVoter(member serf.Member) bool {
+	return member.Tags["role"] != RoleNonvoter
 }
 
 func (d *Discovery) isLocal(member serf.Member) bool {
@@ -118,16 +225,41 @@ func (d *Discovery) Members() []serf.Member {
 	return d.serf.Members()
 }
 
+func (d *Discovery) ListKeys() (*serf.KeyResponse, error) {
+	return d.serf.KeyManager().ListKeys()
+}
+
+func (d *Discovery) InstallKey(key string) (*serf.KeyResponse, error) {
+	d.logger.Info("installing a new gossip key")
+	return d.serf.KeyManager().InstallKey(key)
+}
+
+func (d *Discovery) UseKey(key string) (*serf.KeyResponse, error) {
+	d.logger.Info("changing the primary gossip key")
+	return d.serf.KeyManager().UseKey(key)
+}
+
+func (d *Discovery) RemoveKey(key string) (*serf.KeyResponse, error) {
+	d.logger.Info("removing a gossip key")
+	return d.serf.KeyManager().RemoveKey(key)
+}
+
 func (d *Discovery) Leave() error {
 	return d.serf.Leave()
 }
 
 func (d *Discovery) logError(err error, msg string, member serf.Member) {
-	d.logger.Printf(
-		"Error: %v, Message: %s, Name: %s, RPC Address: %s",
-		err,
+	// every follower sees the event but only the leader can act on it.
+	level := slog.LevelError
+	if errors.Is(err, raft.ErrNotLeader) {
+		level = slog.LevelDebug
+	}
+	d.logger.Log(
+		context.Background(),
+		level,
 		msg,
-		member.Name,
-		member.Tags["rpc_addr"],
+		"error", err,
+		"name", member.Name,
+		"rpc_addr", member.Tags["rpc_addr"],
 	)
 }
@@ -1,13 +1,17 @@
 package game
 
 import (
+	"errors"
 	"fmt"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
-	"log"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -17,27 +21,46 @@ type Config struct {
 		BindAddr    string
 		StreamLayer *StreamLayer
 		Bootstrap   bool
+		// SnapshotRetain is how many snapshots are kept on disk, defaults to 1.
+		SnapshotRetain int
+		// RecoverPeers is the path to a peers.json file, when set the
+		// configuration in it is forced on the local raft state before
+		// starting, see raft.RecoverCluster.
+		RecoverPeers string
 	}
+	// ReadyMaxLag is how many committed entries a node can have left to
+	// apply and still be ready to serve reads.
+	ReadyMaxLag uint64
+	// Logger receives the game and raft logs, nil uses slog.Default.
+	Logger *slog.Logger
 }
 
+var (
+	ErrShutdown      = errors.New("raft is shut down")
+	ErrNoLeader      = errors.New("no known leader")
+	ErrLagging       = errors.New("applied index is lagging behind")
+	ErrUnknownServer = errors.New("unknown server")
+)
+
 type DistributedGame struct {
 	*Game
-	config Config
-	Raft   *raft.Raft
-	logger *log.Logger
+	config    Config
+	Raft      *raft.Raft
+	snapshots raft.SnapshotStore
+	logger    *slog.Logger
 }
 
 func NewDistributedGame(dataDir string, config Config) (*DistributedGame, error) {
 	g := &DistributedGame{
 		config: config,
-		logger: log.New(os.Stdout, "distributed game: ", log.LstdFlags|log.Lshortfile),
+		logger: logging.Component(config.Logger, "game"),
 	}
 	g.Game = New()
 
 	if err := g.setupRaft(dataDir); err != nil {
 		return nil, err
 	}
-	g.logger.Println("DistributedGame initialized successfully")
+	g.logger.Info("distributed game initialized")
 	return g, nil
 }
 
@@ -51,15 +74,15 @@ func (g *DistributedGame) WaitForLeader(timeout time.Duration) error {
 			return fmt.Errorf("timed out")
 		case <-ticker.C:
 			if l, _ := g.Raft.LeaderWithID(); l != "" {
-				g.logger.Printf("Leader found: %s\n", l)
+				g.logger.Info("leader found", "leader_addr", l)
 				return nil
 			}
 		}
 	}
 }
 
-func (g *DistributedGame) Join(id, addr string) error {
-	g.logger.Printf("Joining the cluster with ID: %s and address: %s\n", id, addr)
+func (g *DistributedGame) Join(id, addr string, voter bool) error {
+	g.logger.Info("joining the cluster", "id", id, "addr", addr, "voter", voter)
 	configFuture := g.Raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		return err
@@ -69,25 +92,40 @@ func (g *DistributedGame) Join(id, addr string) error {
 	for _, srv := range configFuture.Configuration().Servers {
 		if srv.ID == serverID || srv.Address == serverAddr {
 			if srv.ID == serverID && srv.Address == serverAddr {
-				g.logger.Printf("Server ( %s , %s ) already in the cluster\n", srv.ID, srv.Address)
-				return nil
+				isVoter := srv.Suffrage == raft.Voter
+				if isVoter == voter {
+					g.logger.Debug("server already in the cluster", "id", srv.ID, "addr", srv.Address)
+					return nil
+				}
+				if isVoter {
+					// AddNonvoter is a no-op for voters, so demote explicitly.
+					g.logger.Info("demoting server to nonvoter", "id", srv.ID, "addr", srv.Address)
+					return g.Raft.DemoteVoter(serverID, 0, 0).Error()
+				}
+				// AddVoter promotes an existing nonvoter.
+				break
 			}
-			g.logger.Printf("Removing server from the cluster: %s\n", srv.ID)
+			g.logger.Info("removing server from the cluster", "id", srv.ID)
 
 			removeFuture := g.Raft.RemoveServer(serverID, 0, 0)
 			if err := removeFuture.Error(); err != nil {
-				g.logger.Printf("Error removing server from the cluster: %s\n", err)
+				g.logger.Error("removing server from the cluster", "id", srv.ID, "error", err)
 
 				return err
 			}
 		}
 	}
-	g.logger.Printf("Adding server to the cluster: %s\n", id)
-	addFuture := g.Raft.AddVoter(serverID, serverAddr, 0, 0)
+	g.logger.Info("adding server to the cluster", "id", id)
+	var addFuture raft.IndexFuture
+	if voter {
+		addFuture = g.Raft.AddVoter(serverID, serverAddr, 0, 0)
+	} else {
+		addFuture = g.Raft.AddNonvoter(serverID, serverAddr, 0, 0)
+	}
 	if addFuture.Error() != nil {
 		return addFuture.Error()
 	}
-	g.logger.Printf("Server ( %s , %s ) joined the cluster successfully\n", serverID, serverAddr)
+	g.logger.Info("server joined the cluster", "id", serverID, "addr", serverAddr)
 
 	return nil
 }
@@ -97,6 +135,154 @@ func (g *DistributedGame) Leave(id string) error {
 	return removeFuture.Error()
 }
 
+func (g *DistributedGame) AddVoter(id, addr string) error {
+	g.logger.Info("adding voter", "id", id, "addr", addr)
+	addFuture := g.Raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	return addFuture.Error()
+}
+
+func (g *DistributedGame) AddNonvoter(id, addr string) error {
+	g.logger.Info("adding nonvoter", "id", id, "addr", addr)
+	addFuture := g.Raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	return addFuture.Error()
+}
+
+func (g *DistributedGame) RemoveServer(id string) error {
+	if err := g.checkServer(id); err != nil {
+		return err
+	}
+	g.logger.Info("removing server", "id", id)
+	removeFuture := g.Raft.RemoveServer(raft.ServerID(id), 0, 0)
+	return removeFuture.Error()
+}
+
+func (g *DistributedGame) DemoteVoter(id string) error {
+	if err := g.checkServer(id); err != nil {
+		return err
+	}
+	g.logger.Info("demoting voter", "id", id)
+	demoteFuture := g.Raft.DemoteVoter(raft.ServerID(id), 0, 0)
+	return demoteFuture.Error()
+}
+
+// TransferLeadership hands leadership over to the server with the given id,
+// if id is empty raft will pick the most up-to-date follower.
+func (g *DistributedGame) TransferLeadership(id string) error {
+	if id == "" {
+		g.logger.Info("transferring leadership")
+		return g.Raft.LeadershipTransfer().Error()
+	}
+	configFuture := g.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(id) {
+			g.logger.Info("transferring leadership", "id", srv.ID, "addr", srv.Address)
+			return g.Raft.LeadershipTransferToServer(srv.ID, srv.Address).Error()
+		}
+	}
+	return fmt.Errorf("%w %s", ErrUnknownServer, id)
+}
+
+// checkServer fails with ErrUnknownServer when id is not part of the
+// cluster, raft would silently do nothing.
+func (g *DistributedGame) checkServer(id string) error {
+	configFuture := g.Raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(id) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %s", ErrUnknownServer, id)
+}
+
+// Snapshot forces raft to take a snapshot of the current state.
+func (g *DistributedGame) Snapshot() (*api.SnapshotMeta, error) {
+	g.logger.Info("taking a user snapshot")
+	future := g.Raft.Snapshot()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	meta, rc, err := future.Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := rc.Close(); err != nil {
+		return nil, err
+	}
+	return convertSnapshotMeta(meta), nil
+}
+
+// ListSnapshots lists the snapshots retained on disk, newest first.
+func (g *DistributedGame) ListSnapshots() ([]*api.SnapshotMeta, error) {
+	metas, err := g.snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*api.SnapshotMeta, 0, len(metas))
+	for _, meta := range metas {
+		snapshots = append(snapshots, convertSnapshotMeta(meta))
+	}
+	return snapshots, nil
+}
+
+// Backup opens the latest snapshot so it can be copied out of the cluster,
+// a fresh snapshot is taken first unless nothing changed since the last one.
+func (g *DistributedGame) Backup() (*api.SnapshotMeta, io.ReadCloser, error) {
+	g.logger.Info("backing up the latest snapshot")
+	future := g.Raft.Snapshot()
+	err := future.Error()
+	if err == nil {
+		meta, rc, err := future.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		return convertSnapshotMeta(meta), rc, nil
+	}
+	if !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+		return nil, nil, err
+	}
+	metas, err := g.snapshots.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metas) == 0 {
+		return nil, nil, errors.New("no snapshot available")
+	}
+	meta, rc, err := g.snapshots.Open(metas[0].ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return convertSnapshotMeta(meta), rc, nil
+}
+
+// Restore replaces the state of the cluster with the given snapshot, it can
+// only run on the leader and blocks until followers caught up with it.
+func (g *DistributedGame) Restore(meta *api.SnapshotMeta, r io.Reader) error {
+	g.logger.Warn("restoring snapshot", "snapshot_id", meta.Id, "index", meta.Index)
+	return g.Raft.Restore(&raft.SnapshotMeta{
+		Version: raft.SnapshotVersion(meta.Version),
+		ID:      meta.Id,
+		Index:   meta.Index,
+		Term:    meta.Term,
+		Size:    meta.Size,
+	}, r, 0)
+}
+
+func convertSnapshotMeta(meta *raft.SnapshotMeta) *api.SnapshotMeta {
+	return &api.SnapshotMeta{
+		Id:      meta.ID,
+		Index:   meta.Index,
+		Term:    meta.Term,
+		Size:    meta.Size,
+		Version: int32(meta.Version),
+	}
+}
+
 func (g *DistributedGame) GetServers() ([]*api.Server, error) {
 	future := g.Raft.GetConfiguration()
 	if err := future.Error(); err != nil {
@@ -106,23 +292,132 @@ func (g *DistributedGame) GetServers() ([]*api.Server, error) {
 	leaderAdrr, _ := g.Raft.LeaderWithID()
 	for _, server := range future.Configuration().Servers {
 		servers = append(servers, &api.Server{
-			Id:       string(server.ID),
-			RpcAddr:  string(server.Address),
-			IsLeader: leaderAdrr == server.Address,
+			Id:         string(server.ID),
+			RpcAddr:    string(server.Address),
+			IsLeader:   leaderAdrr == server.Address,
+			IsNonvoter: server.Suffrage != raft.Voter,
 		})
 	}
 	return servers, nil
 }
 
+// WatchServers signals changes of the leader and of the servers raft
+// replicates to, a signal can stand for several changes. Followers aren't
+// told about every configuration change, so watchers should still refresh
+// now and then. stop unregisters the watch.
+func (g *DistributedGame) WatchServers() (changes <-chan struct{}, stop func()) {
+	observations := make(chan raft.Observation, 1)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.PeerObservation:
+			return true
+		default:
+			return false
+		}
+	})
+	g.Raft.RegisterObserver(observer)
+	signals := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-observations:
+				select {
+				case signals <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return signals, func() {
+		g.Raft.DeregisterObserver(observer)
+		close(done)
+	}
+}
+
+// Live reports whether raft is running, a node that is partitioned or
+// catching up is still live.
+func (g *DistributedGame) Live() error {
+	if g.Raft.State() == raft.Shutdown {
+		return ErrShutdown
+	}
+	return nil
+}
+
+// Ready reports whether the node can serve reads, it needs to know the
+// leader and to have applied close to everything that was committed.
+func (g *DistributedGame) Ready() error {
+	if err := g.Live(); err != nil {
+		return err
+	}
+	if leaderAddr, _ := g.Raft.LeaderWithID(); leaderAddr == "" {
+		return ErrNoLeader
+	}
+	// raft v1.5 only exposes the commit index through its stats.
+	commitIndex, err := strconv.ParseUint(g.Raft.Stats()["commit_index"], 10, 64)
+	if err != nil {
+		return err
+	}
+	appliedIndex := g.Raft.AppliedIndex()
+	if commitIndex > appliedIndex && commitIndex-appliedIndex > g.config.ReadyMaxLag {
+		return fmt.Errorf(
+			"%w: applied %d, committed %d",
+			ErrLagging,
+			appliedIndex,
+			commitIndex,
+		)
+	}
+	return nil
+}
+
 func (g *DistributedGame) Close() error {
 	f := g.Raft.Shutdown()
 	return f.Error()
 }
 
+// recoverCluster rewrites the local raft configuration with the one in
+// RecoverPeers, this is how a cluster that lost its quorum can be brought
+// back from the surviving servers.
+func (g *DistributedGame) recoverCluster(
+	config *raft.Config,
+	logStore raft.LogStore,
+	stableStore raft.StableStore,
+	snapshotStore raft.SnapshotStore,
+	transport raft.Transport,
+) error {
+	g.logger.Warn("recovering the cluster", "peers_file", g.config.Raft.RecoverPeers)
+	configuration, err := raft.ReadConfigJSON(g.config.Raft.RecoverPeers)
+	if err != nil {
+		return fmt.Errorf("reading peers file: %w", err)
+	}
+	// RecoverCluster leaves the FSM it is given in an unusable state,
+	// so it gets a throwaway one.
+	recoveryFSM := fsm{game: New(), logger: g.logger}
+	if err := raft.RecoverCluster(
+		config,
+		recoveryFSM,
+		logStore,
+		stableStore,
+		snapshotStore,
+		transport,
+		configuration,
+	); err != nil {
+		return fmt.Errorf("recovering cluster: %w", err)
+	}
+	g.logger.Warn(
+		"cluster recovered, remove the peers file before the next restart",
+		"servers", fmt.Sprintf("%+v", configuration.Servers),
+	)
+	return nil
+}
+
 func (g *DistributedGame) setupRaft(dataDir string) error {
-	g.logger.Println("setting up raft")
+	g.logger.Debug("setting up raft")
 
-	fsm := fsm{game: g.Game}
+	fsm := fsm{game: g.Game, logger: g.logger}
+	raftLogger := logging.HCLog(g.config.Logger, "raft")
 
 	logDir := filepath.Join(dataDir, "raft")
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -144,26 +439,31 @@ func (g *DistributedGame) setupRaft(dataDir string) error {
 	}
 
 	retain := 1
-	snapshotStore, err := raft.NewFileSnapshotStore(
+	if g.config.Raft.SnapshotRetain != 0 {
+		retain = g.config.Raft.SnapshotRetain
+	}
+	snapshotStore, err := raft.NewFileSnapshotStoreWithLogger(
 		filepath.Join(dataDir, "raft", "log"),
 		retain,
-		os.Stderr,
+		raftLogger.Named("snapshot"),
 	)
 	if err != nil {
 		return err
 	}
+	g.snapshots = snapshotStore
 
 	maxPool := 5
 	timeout := 10 * time.Second
-	transport := raft.NewNetworkTransport(
-		g.config.Raft.StreamLayer,
-		maxPool,
-		timeout,
-		os.Stderr,
-	)
+	transport := raft.NewNetworkTransportWithConfig(&raft.NetworkTransportConfig{
+		Stream:  g.config.Raft.StreamLayer,
+		MaxPool: maxPool,
+		Timeout: timeout,
+		Logger:  raftLogger.Named("net"),
+	})
 
 	config := raft.DefaultConfig()
 	config.LocalID = g.config.Raft.LocalID
+	config.Logger = raftLogger
 	if g.config.Raft.HeartbeatTimeout != 0 {
 		config.HeartbeatTimeout = g.config.Raft.HeartbeatTimeout
 	}
@@ -176,6 +476,18 @@ func (g *DistributedGame) setupRaft(dataDir string) error {
 	if g.config.Raft.CommitTimeout != 0 {
 		config.CommitTimeout = g.config.Raft.CommitTimeout
 	}
+	if g.config.Raft.SnapshotInterval != 0 {
+		config.SnapshotInterval = g.config.Raft.SnapshotInterval
+	}
+	if g.config.Raft.SnapshotThreshold != 0 {
+		config.SnapshotThreshold = g.config.Raft.SnapshotThreshold
+	}
+
+	if g.config.Raft.RecoverPeers != "" {
+		if err := g.recoverCluster(config, logStore, stableStore, snapshotStore, transport); err != nil {
+			return err
+		}
+	}
 
 	g.Raft, err = raft.NewRaft(
 		config,
@@ -205,6 +517,6 @@ func (g *DistributedGame) setupRaft(dataDir string) error {
 		}
 		err = g.Raft.BootstrapCluster(config).Error()
 	}
-	g.logger.Println("Done setting up raft")
+	g.logger.Debug("done setting up raft")
 	return err
 }
@@ -1,41 +1,40 @@
 package game
 
 import (
-	"fmt"
+	"context"
 	"github.com/hashicorp/raft"
 	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 	"io"
-	"log"
-	"sync"
+	"log/slog"
 )
 
 var _ raft.FSM = (*fsm)(nil)
 
+var tracer = otel.Tracer("github.com/khatibomar/dhangkanna/internal/game")
+
 type fsm struct {
-	game *Game
+	game   *Game
+	logger *slog.Logger
 }
 
 func (f fsm) Snapshot() (raft.FSMSnapshot, error) {
-	fmt.Println("snapshotting in fsm")
+	f.logger.Debug("snapshotting the fsm")
 
-	g := &api.Game{
-		GuessedCharacter: f.game.GuessedCharacter,
-		IncorrectGuesses: f.game.IncorrectGuesses,
-		ChancesLeft:      int32(f.game.ChancesLeft),
-		GameState:        int32(f.game.GameState),
-		Message:          f.game.Message,
-		Version:          int32(f.game.Version),
-	}
+	g := ConvertGameToGameApi(f.game.Copy())
 	snapshotData, err := proto.Marshal(g)
 	if err != nil {
 		return nil, err
 	}
-	return &fsmSnapshot{data: snapshotData}, nil
+	return &fsmSnapshot{data: snapshotData, logger: f.logger}, nil
 }
 
 func (f fsm) Restore(snapshot io.ReadCloser) error {
-	log.Println("restoring in fsm...")
+	f.logger.Info("restoring the fsm from a snapshot")
 
 	data, err := io.ReadAll(snapshot)
 	if err != nil {
@@ -48,20 +47,33 @@ func (f fsm) Restore(snapshot io.ReadCloser) error {
 		return err
 	}
 
-	f.game = &Game{
-		GuessedCharacter: gameSnapshot.GuessedCharacter,
-		IncorrectGuesses: gameSnapshot.IncorrectGuesses,
-		ChancesLeft:      int(gameSnapshot.ChancesLeft),
-		GameState:        int8(gameSnapshot.GameState),
-		Message:          gameSnapshot.Message,
-		Version:          int(gameSnapshot.Version),
-		mu:               &sync.Mutex{},
+	if gameSnapshot.IncorrectGuesses == nil {
+		gameSnapshot.IncorrectGuesses = make([]string, 0)
 	}
+
+	// update in place, the game is shared with DistributedGame.
+	f.game.Update(
+		gameSnapshot.GuessedCharacter,
+		gameSnapshot.IncorrectGuesses,
+		int(gameSnapshot.ChancesLeft),
+		int8(gameSnapshot.GameState),
+		gameSnapshot.Message,
+		int(gameSnapshot.Version),
+	)
 	return nil
 }
 
 func (f fsm) Apply(record *raft.Log) any {
-	log.Println("Applying the state in fsm...")
+	f.logger.Debug("applying log entry", "index", record.Index, "term", record.Term)
+	_, span := tracer.Start(
+		telemetry.Extract(context.Background(), record.Extensions),
+		"fsm.Apply",
+		trace.WithAttributes(
+			attribute.Int64("raft.index", int64(record.Index)),
+			attribute.Int64("raft.term", int64(record.Term)),
+		),
+	)
+	defer span.End()
 
 	var req api.Game
 	err := proto.Unmarshal(record.Data, &req)
@@ -2,13 +2,18 @@ package game
 
 import (
 	"fmt"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
 	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
 	"github.com/khatibomar/dhangkanna/internal"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 )
 
+// DefaultID names the game of the cluster, there is a single one for now.
+const DefaultID = "default"
+
 const characterName = "kanna kamui"
 const initialChances = 6
 
@@ -59,6 +64,22 @@ func (g *Game) Update(
 	g.Version = version
 }
 
+// Copy returns the game as it is under its lock, the copy shares nothing
+// with g so it can be read while guesses are being applied.
+func (g *Game) Copy() Game {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return Game{
+		GuessedCharacter: slices.Clone(g.GuessedCharacter),
+		IncorrectGuesses: slices.Clone(g.IncorrectGuesses),
+		ChancesLeft:      g.ChancesLeft,
+		GameState:        g.GameState,
+		Message:          g.Message,
+		Version:          g.Version,
+	}
+}
+
 func (g *Game) HandleNewLetter(letter string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -132,6 +153,51 @@ func ConvertGameApiToGame(apiGame *api.Game) Game {
 	return g
 }
 
+// ConvertGameToGameV1 converts a game to the dhangkanna.v1 API, the
+// states share their values.
+func ConvertGameToGameV1(id string, game Game) *dhangkannav1.Game {
+	g := &dhangkannav1.Game{
+		Id:                id,
+		GuessedCharacters: game.GuessedCharacter,
+		IncorrectGuesses:  game.IncorrectGuesses,
+		ChancesLeft:       int32(game.ChancesLeft),
+		State:             dhangkannav1.GameState(game.GameState),
+		Message:           game.Message,
+		Version:           int64(game.Version),
+	}
+
+	if g.GuessedCharacters == nil {
+		g.GuessedCharacters = make([]string, 0)
+	}
+
+	if g.IncorrectGuesses == nil {
+		g.IncorrectGuesses = make([]string, 0)
+	}
+
+	return g
+}
+
+func ConvertGameV1ToGame(apiGame *dhangkannav1.Game) Game {
+	g := Game{
+		GuessedCharacter: apiGame.GetGuessedCharacters(),
+		IncorrectGuesses: apiGame.GetIncorrectGuesses(),
+		ChancesLeft:      int(apiGame.GetChancesLeft()),
+		GameState:        int8(apiGame.GetState()),
+		Message:          apiGame.GetMessage(),
+		Version:          int(apiGame.GetVersion()),
+	}
+
+	if g.GuessedCharacter == nil {
+		g.GuessedCharacter = make([]string, 0)
+	}
+
+	if g.IncorrectGuesses == nil {
+		g.IncorrectGuesses = make([]string, 0)
+	}
+
+	return g
+}
+
 func initializeGuessedCharacter(characterName string) []string {
 	guessedCharacter := make([]string, len(characterName))
 	for i, char := range characterName {
@@ -2,17 +2,18 @@ package game
 
 import (
 	"github.com/hashicorp/raft"
-	"log"
+	"log/slog"
 )
 
 var _ raft.FSMSnapshot = (*fsmSnapshot)(nil)
 
 type fsmSnapshot struct {
-	data []byte
+	data   []byte
+	logger *slog.Logger
 }
 
 func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
-	log.Println("Taking a snapshot...")
+	s.logger.Debug("persisting snapshot", "snapshot_id", sink.ID())
 	_, err := sink.Write(s.data)
 	if err != nil {
 		if err2 := sink.Cancel(); err2 != nil {
@@ -24,7 +25,7 @@ func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	if err := sink.Close(); err != nil {
 		return err
 	}
-	log.Println("Taking snapshot done, with no errors")
+	s.logger.Debug("snapshot persisted", "snapshot_id", sink.ID())
 	return nil
 }
 
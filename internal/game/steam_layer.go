@@ -17,11 +17,17 @@ type StreamLayer struct {
 	peerTLSConfig   *tls.Config
 }
 
+// NewStreamLayer returns a StreamLayer accepting raft connections on ln, when
+// both TLS configs are set peers must authenticate each other with mTLS.
 func NewStreamLayer(
 	ln net.Listener,
+	serverTLSConfig,
+	peerTLSConfig *tls.Config,
 ) *StreamLayer {
 	return &StreamLayer{
-		ln: ln,
+		ln:              ln,
+		serverTLSConfig: serverTLSConfig,
+		peerTLSConfig:   peerTLSConfig,
 	}
 }
 
@@ -42,11 +48,26 @@ func (s *StreamLayer) Dial(
 		return nil, err
 	}
 	if s.peerTLSConfig != nil {
-		conn = tls.Client(conn, s.peerTLSConfig)
+		conn = tls.Client(conn, s.peerTLSConfigFor(addr))
 	}
 	return conn, err
 }
 
+// peerTLSConfigFor verifies the peer certificate against the host we dial
+// unless a server name was configured explicitly.
+func (s *StreamLayer) peerTLSConfigFor(addr raft.ServerAddress) *tls.Config {
+	if s.peerTLSConfig.ServerName != "" {
+		return s.peerTLSConfig
+	}
+	host, _, err := net.SplitHostPort(string(addr))
+	if err != nil {
+		return s.peerTLSConfig
+	}
+	cfg := s.peerTLSConfig.Clone()
+	cfg.ServerName = host
+	return cfg
+}
+
 func (s *StreamLayer) Accept() (net.Conn, error) {
 	conn, err := s.ln.Accept()
 	if err != nil {
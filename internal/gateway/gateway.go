@@ -0,0 +1,383 @@
+// Package gateway maps a REST API onto the GameService, for consumers that
+// don't speak gRPC.
+//
+//	GET  /games/{id}                game state, ?consistency=strong reads it from the leader
+//	POST /games/{id}/guesses        {"letter": "k"}, answers with the game state
+//	POST /games/{id}/reset          needs a moderator bearer token, answers with the game state
+//	GET  /cluster/servers           servers of the raft cluster
+//
+// Errors are answered as {"error": {"code": "NOT_FOUND", "message": "..."}}
+// where code is the name of the gRPC status code.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Client returns the client the calls go through, it is called on
+	// every request.
+	Client func() (dhangkannav1.GameServiceClient, error)
+	// Limiter rate limits guesses and resets per client IP, nil disables
+	// it.
+	Limiter *ratelimit.Limiter
+	// OnChange is called after a guess or a reset went through, nil
+	// ignores them.
+	OnChange func(ctx context.Context)
+	// Logger receives the gateway logs, nil uses slog.Default.
+	Logger *slog.Logger
+}
+
+type Game struct {
+	ID string `json:"id"`
+	game.Game
+}
+
+type Server struct {
+	ID       string `json:"id"`
+	RPCAddr  string `json:"rpcAddr"`
+	Leader   bool   `json:"leader"`
+	Nonvoter bool   `json:"nonvoter"`
+}
+
+type Servers struct {
+	Servers []Server `json:"servers"`
+}
+
+type Guess struct {
+	Letter string `json:"letter"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type gateway struct {
+	Config
+	logger *slog.Logger
+}
+
+// Handler serves the API under /games/ and /cluster/.
+func Handler(config Config) http.Handler {
+	g := &gateway{
+		Config: config,
+		logger: logging.Component(config.Logger, "gateway"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/games/", g.handleGames)
+	mux.HandleFunc("/cluster/servers", g.handleServers)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		g.writeError(w, codes.NotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+	})
+	return mux
+}
+
+// handleGames routes /games/{id} and its actions, the mux of Go 1.21 has
+// no path parameters.
+func (g *gateway) handleGames(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/games/"), "/")
+	if id == "" {
+		g.writeError(w, codes.NotFound, "no game id in the path")
+		return
+	}
+	switch action {
+	case "":
+		if allowMethod(w, r, http.MethodGet) {
+			g.getGame(w, r, id)
+		}
+	case "guesses":
+		if allowMethod(w, r, http.MethodPost) {
+			g.guess(w, r, id)
+		}
+	case "reset":
+		if allowMethod(w, r, http.MethodPost) {
+			g.reset(w, r, id)
+		}
+	default:
+		g.writeError(w, codes.NotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+	}
+}
+
+func (g *gateway) getGame(w http.ResponseWriter, r *http.Request, id string) {
+	if !g.checkGame(w, id) {
+		return
+	}
+	consistency, err := loadbalance.ParseConsistency(r.URL.Query().Get("consistency"))
+	if err != nil {
+		g.writeError(w, codes.InvalidArgument, err.Error())
+		return
+	}
+	g.writeGame(w, r.Context(), id, consistency)
+}
+
+func (g *gateway) guess(w http.ResponseWriter, r *http.Request, id string) {
+	if !g.checkGame(w, id) || !g.allow(w, r) {
+		return
+	}
+	var guess Guess
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&guess); err != nil {
+		g.writeError(w, codes.InvalidArgument, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	if guess.Letter == "" {
+		g.writeError(w, codes.InvalidArgument, "letter is required")
+		return
+	}
+	c, ok := g.client(w)
+	if !ok {
+		return
+	}
+	res, err := c.Guess(ratelimit.WithPlayer(r.Context(), clientKey(r)), &dhangkannav1.GuessRequest{GameId: id, Letter: strings.ToLower(guess.Letter)})
+	if err != nil {
+		g.writeRPCError(w, err)
+		return
+	}
+	g.changed(r.Context())
+	writeJSON(w, http.StatusOK, convertGame(res.Game))
+}
+
+// reset restarts the game for everybody, it is called with the bearer token
+// of the caller rather than the one of the frontend, and the backends check
+// it has the moderator role.
+func (g *gateway) reset(w http.ResponseWriter, r *http.Request, id string) {
+	if !g.checkGame(w, id) {
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		g.writeError(w, codes.Unauthenticated, "reset needs a bearer token with the moderator role")
+		return
+	}
+	if !g.allow(w, r) {
+		return
+	}
+	c, ok := g.client(w)
+	if !ok {
+		return
+	}
+	res, err := c.ResetGame(auth.WithToken(r.Context(), token), &dhangkannav1.ResetGameRequest{GameId: id})
+	if err != nil {
+		g.writeRPCError(w, err)
+		return
+	}
+	g.changed(r.Context())
+	writeJSON(w, http.StatusOK, convertGame(res.Game))
+}
+
+func (g *gateway) handleServers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	c, ok := g.client(w)
+	if !ok {
+		return
+	}
+	res, err := c.ListServers(r.Context(), &dhangkannav1.ListServersRequest{})
+	if err != nil {
+		g.writeRPCError(w, err)
+		return
+	}
+	servers := Servers{Servers: make([]Server, 0, len(res.Servers))}
+	for _, srv := range res.Servers {
+		servers.Servers = append(servers.Servers, Server{
+			ID:       srv.Id,
+			RPCAddr:  srv.RpcAddr,
+			Leader:   srv.IsLeader,
+			Nonvoter: srv.IsNonvoter,
+		})
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
+
+func (g *gateway) writeGame(w http.ResponseWriter, ctx context.Context, id string, consistency loadbalance.Consistency) {
+	c, ok := g.client(w)
+	if !ok {
+		return
+	}
+	res, err := c.GetGame(loadbalance.WithConsistency(ctx, consistency), &dhangkannav1.GetGameRequest{GameId: id})
+	if err != nil {
+		g.writeRPCError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, convertGame(res.Game))
+}
+
+func convertGame(g *dhangkannav1.Game) Game {
+	return Game{ID: g.GetId(), Game: game.ConvertGameV1ToGame(g)}
+}
+
+// checkGame answers 404 for the games the cluster doesn't have, it runs a
+// single one for now.
+func (g *gateway) checkGame(w http.ResponseWriter, id string) bool {
+	if id != game.DefaultID {
+		g.writeError(w, codes.NotFound, fmt.Sprintf("unknown game %q", id))
+		return false
+	}
+	return true
+}
+
+func (g *gateway) allow(w http.ResponseWriter, r *http.Request) bool {
+	if g.Limiter == nil {
+		return true
+	}
+	ok, retryAfter := g.Limiter.Allow(clientKey(r))
+	if !ok {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		g.writeError(w, codes.ResourceExhausted, fmt.Sprintf("too many requests, retry in %s", retryAfter.Round(100*time.Millisecond)))
+	}
+	return ok
+}
+
+func (g *gateway) client(w http.ResponseWriter) (dhangkannav1.GameServiceClient, bool) {
+	c, err := g.Client()
+	if err != nil {
+		g.logger.Error("getting a backend client", "error", err)
+		g.writeError(w, codes.Unavailable, err.Error())
+		return nil, false
+	}
+	return c, true
+}
+
+func (g *gateway) changed(ctx context.Context) {
+	if g.OnChange != nil {
+		g.OnChange(ctx)
+	}
+}
+
+func (g *gateway) writeRPCError(w http.ResponseWriter, err error) {
+	st, _ := status.FromError(err)
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(info.RetryDelay.AsDuration()))
+		}
+	}
+	if errors.Is(err, context.Canceled) || st.Code() == codes.Canceled {
+		// the client went away, nobody reads the answer.
+		return
+	}
+	statusCode := rpcStatus(err)
+	if statusCode >= http.StatusInternalServerError {
+		g.logger.Warn("backend call failed", "code", st.Code().String(), "error", st.Message())
+	}
+	writeJSON(w, statusCode, errorBody(st.Code(), st.Message()))
+}
+
+func (g *gateway) writeError(w http.ResponseWriter, code codes.Code, message string) {
+	writeJSON(w, httpStatus(code), errorBody(code, message))
+}
+
+func errorBody(code codes.Code, message string) any {
+	return struct {
+		Error Error `json:"error"`
+	}{Error{Code: codeName(code), Message: message}}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, struct {
+		Error Error `json:"error"`
+	}{Error{Code: "METHOD_NOT_ALLOWED", Message: fmt.Sprintf("%s only accepts %s", r.URL.Path, method)}})
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// rpcStatus is the status of a failed backend call, a follower answering
+// NOT_LEADER is a 503 rather than the 400 of its FAILED_PRECONDITION since
+// the call can be retried as is once the cluster has a leader.
+func rpcStatus(err error) int {
+	if loadbalance.IsNotLeader(err) {
+		return http.StatusServiceUnavailable
+	}
+	return httpStatus(status.Code(err))
+}
+
+// httpStatus follows the mapping of the gRPC gateway.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeName is the name of code as written in the gRPC spec, NOT_FOUND
+// rather than NotFound.
+func codeName(code codes.Code) string {
+	var b strings.Builder
+	for i, r := range code.String() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// clientKey identifies clients by IP, like the players of the websocket.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.Canceled, 499},
+		{codes.Unknown, http.StatusInternalServerError},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.AlreadyExists, http.StatusConflict},
+		{codes.PermissionDenied, http.StatusForbidden},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.FailedPrecondition, http.StatusBadRequest},
+		{codes.Aborted, http.StatusConflict},
+		{codes.OutOfRange, http.StatusBadRequest},
+		{codes.Unimplemented, http.StatusNotImplemented},
+		{codes.Internal, http.StatusInternalServerError},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.DataLoss, http.StatusInternalServerError},
+		{codes.Unauthenticated, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := httpStatus(tt.code); got != tt.want {
+			t.Errorf("httpStatus(%s) = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestRPCStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not leader", loadbalance.NotLeaderError("node1", "127.0.0.1:8400"), http.StatusServiceUnavailable},
+		{"not leader during an election", loadbalance.NotLeaderError("", ""), http.StatusServiceUnavailable},
+		{"other failed precondition", status.Error(codes.FailedPrecondition, "game is over"), http.StatusBadRequest},
+		{"unavailable", status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable},
+		{"not a status", errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := rpcStatus(tt.err); got != tt.want {
+			t.Errorf("%s: rpcStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+type fakeGameClient struct {
+	dhangkannav1.GameServiceClient
+	// authorization is what the last ResetGame sent as its bearer token.
+	authorization string
+}
+
+func (c *fakeGameClient) ResetGame(ctx context.Context, in *dhangkannav1.ResetGameRequest, _ ...grpc.CallOption) (*dhangkannav1.ResetGameResponse, error) {
+	md, err := auth.BearerToken("frontend-token", false).GetRequestMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.authorization = md["authorization"]
+	return &dhangkannav1.ResetGameResponse{Game: &dhangkannav1.Game{Id: in.GameId}}, nil
+}
+
+func TestResetForwardsTheCallerToken(t *testing.T) {
+	tests := []struct {
+		name              string
+		authorization     string
+		wantStatus        int
+		wantAuthorization string
+	}{
+		{"no token", "", http.StatusUnauthorized, ""},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized, ""},
+		{"empty bearer token", "Bearer ", http.StatusUnauthorized, ""},
+		{"bearer token", "Bearer moderator-token", http.StatusOK, "Bearer moderator-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeGameClient{}
+			h := Handler(Config{
+				Client: func() (dhangkannav1.GameServiceClient, error) { return c, nil },
+			})
+			r := httptest.NewRequest(http.MethodPost, "/games/"+game.DefaultID+"/reset", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if c.authorization != tt.wantAuthorization {
+				t.Fatalf("backend got authorization %q, want %q", c.authorization, tt.wantAuthorization)
+			}
+		})
+	}
+}
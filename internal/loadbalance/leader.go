@@ -0,0 +1,104 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ErrorDomain is the domain of the ErrorInfo details sent by servers.
+	ErrorDomain = "dhangkanna"
+	// ReasonNotLeader is sent by a server asked for something only the
+	// leader does, nothing was applied so the call can be retried.
+	ReasonNotLeader = "NOT_LEADER"
+)
+
+// NotLeaderError tells the client which server is the leader as far as the
+// server that failed knows, both can be empty during an election.
+func NotLeaderError(leaderID, leaderAddr string) error {
+	st, err := status.New(codes.FailedPrecondition, "not the leader").WithDetails(&errdetails.ErrorInfo{
+		Reason: ReasonNotLeader,
+		Domain: ErrorDomain,
+		Metadata: map[string]string{
+			"leader_id":   leaderID,
+			"leader_addr": leaderAddr,
+		},
+	})
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, "not the leader")
+	}
+	return st.Err()
+}
+
+// IsNotLeader reports whether err was returned by a server that isn't the
+// leader.
+func IsNotLeader(err error) bool {
+	st, ok := status.FromError(err)
+	if err == nil || !ok {
+		return false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Domain == ErrorDomain && info.Reason == ReasonNotLeader
+		}
+	}
+	return false
+}
+
+// ConsistencyKey is the metadata key telling where a read is served.
+const ConsistencyKey = "dhangkanna-consistency"
+
+type Consistency string
+
+const (
+	// Eventual reads are served by any server, they can miss the latest
+	// guesses while a follower catches up.
+	Eventual Consistency = "eventual"
+	// Strong reads are served by the leader once it confirmed it still
+	// leads, they see every guess acknowledged before them.
+	Strong Consistency = "strong"
+)
+
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(s); c {
+	case "":
+		return Eventual, nil
+	case Eventual, Strong:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown consistency %q", s)
+	}
+}
+
+// WithConsistency asks the calls made with ctx to be served with c.
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, ConsistencyKey, string(c))
+}
+
+// IncomingConsistency is the consistency a server was asked for, Eventual
+// when the client didn't say.
+func IncomingConsistency(ctx context.Context) Consistency {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return consistencyFrom(md)
+}
+
+func outgoingConsistency(ctx context.Context) Consistency {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return consistencyFrom(md)
+}
+
+func consistencyFrom(md metadata.MD) Consistency {
+	values := md.Get(ConsistencyKey)
+	if len(values) == 0 {
+		return Eventual
+	}
+	c, err := ParseConsistency(values[len(values)-1])
+	if err != nil {
+		return Eventual
+	}
+	return c
+}
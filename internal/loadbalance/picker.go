@@ -3,43 +3,102 @@ package loadbalance
 import (
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
-	"log"
-	"strings"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"math/rand"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var _ base.PickerBuilder = (*Picker)(nil)
 
 type Picker struct {
+	// cc is told to resolve the servers again when a call shows the picker
+	// is out of date.
+	cc        balancer.ClientConn
 	mu        sync.RWMutex
 	leader    balancer.SubConn
 	followers []balancer.SubConn
+	// nonvoters are read replicas, they only ever serve Receive.
+	nonvoters []balancer.SubConn
 	current   uint64
+
+	// resolvedAt is the UnixNano of the last resolution asked for.
+	resolvedAt atomic.Int64
+
+	statsMu sync.Mutex
+	stats   map[balancer.SubConn]*subConnStats
+	// maxApplied is the highest applied index reported by any server.
+	maxApplied uint64
 }
 
 func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	var followers []balancer.SubConn
+	var leader balancer.SubConn
+	var followers, nonvoters []balancer.SubConn
 	for sc, scInfo := range buildInfo.ReadySCs {
-		isLeader := scInfo.
-			Address.
-			Attributes.
-			Value("is_leader").(bool)
+		attrs := scInfo.Address.Attributes
+		isLeader := attrs.Value("is_leader").(bool)
 		if isLeader {
-			p.leader = sc
+			leader = sc
+			continue
+		}
+		if isNonvoter, _ := attrs.Value("is_nonvoter").(bool); isNonvoter {
+			nonvoters = append(nonvoters, sc)
 			continue
 		}
 		followers = append(followers, sc)
 	}
-	log.Printf("leader: %+v\n", p.leader)
-	log.Printf("followers: %+v\n", p.followers)
 
+	changed := p.serversChanged(buildInfo.ReadySCs)
+	p.leader = leader
 	p.followers = followers
+	p.nonvoters = nonvoters
+	p.statsMu.Lock()
+	if p.stats == nil {
+		p.stats = make(map[balancer.SubConn]*subConnStats)
+	}
+	for sc := range p.stats {
+		if _, ok := buildInfo.ReadySCs[sc]; !ok {
+			delete(p.stats, sc)
+		}
+	}
+	if changed {
+		p.resetMaxApplied()
+	}
+	p.statsMu.Unlock()
+	slog.Debug(
+		"picker built",
+		"component", "picker",
+		"leader", p.leader,
+		"followers", p.followers,
+		"nonvoters", p.nonvoters,
+	)
 	return p
 }
 
+// serversChanged reports whether ready isn't the servers of the last
+// build, p.mu must be held.
+func (p *Picker) serversChanged(ready map[balancer.SubConn]base.SubConnInfo) bool {
+	servers := len(p.followers) + len(p.nonvoters)
+	if p.leader != nil {
+		servers++
+		if _, ok := ready[p.leader]; !ok {
+			return true
+		}
+	}
+	for i := 0; i < len(p.followers)+len(p.nonvoters); i++ {
+		if _, ok := ready[p.reader(i)]; !ok {
+			return true
+		}
+	}
+	return servers != len(ready)
+}
+
 var _ balancer.Picker = (*Picker)(nil)
 
 func (p *Picker) Pick(info balancer.PickInfo) (
@@ -47,27 +106,132 @@ func (p *Picker) Pick(info balancer.PickInfo) (
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	var result balancer.PickResult
-	if strings.Contains(info.FullMethodName, "Send") || len(p.followers) == 0 {
+	route := routeOf(info.FullMethodName)
+	switch {
+	case route == FollowerPreferred && outgoingConsistency(info.Ctx) == Strong:
+		// strong reads need the leader to confirm it still leads.
+		result.SubConn = p.leader
+	case route == FollowerPreferred:
+		result.SubConn = p.pickReader()
+		if result.SubConn == nil {
+			result.SubConn = p.leader
+		}
+	case route == Any:
+		result.SubConn = p.nextAny()
+	default:
 		result.SubConn = p.leader
-	} else if strings.Contains(info.FullMethodName, "Receive") {
-		result.SubConn = p.nextFollower()
 	}
 	if result.SubConn == nil {
 		return result, balancer.ErrNoSubConnAvailable
 	}
-	log.Printf("picked: %+v\n", result)
+	sc, start := result.SubConn, time.Now()
+	result.Done = func(info balancer.DoneInfo) {
+		p.observe(sc, time.Since(start), info)
+		if IsNotLeader(info.Err) || status.Code(info.Err) == codes.Unavailable {
+			p.resolveNow()
+		}
+	}
+	slog.Debug(
+		"picked",
+		"component", "picker",
+		"method", info.FullMethodName,
+		"route", route,
+		"subconn", result.SubConn,
+	)
 	return result, nil
 }
 
-func (p *Picker) nextFollower() balancer.SubConn {
+// minResolveInterval keeps a burst of failing calls from listing the
+// servers over and over.
+const minResolveInterval = 500 * time.Millisecond
+
+// resolveNow asks for the servers again after a call reached a server that
+// isn't the leader anymore or that went away, so the retries are routed
+// with the new leader.
+func (p *Picker) resolveNow() {
+	if p.cc == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := p.resolvedAt.Load()
+	if now-last < int64(minResolveInterval) || !p.resolvedAt.CompareAndSwap(last, now) {
+		return
+	}
+	slog.Debug("resolving servers after a failed call", "component", "picker")
+	p.cc.ResolveNow(resolver.ResolveNowOptions{})
+}
+
+// pickReader picks a follower or nonvoter at random, weighted by how fast
+// they answered so far, those lagging behind are left out. It is nil when
+// none can serve the read.
+func (p *Picker) pickReader() balancer.SubConn {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	var candidates []balancer.SubConn
+	var weights []float64
+	var total float64
+	for i := 0; i < len(p.followers)+len(p.nonvoters); i++ {
+		sc := p.reader(i)
+		if p.lagging(sc) {
+			continue
+		}
+		w := 1 / max(p.latency(sc).Seconds(), minLatency.Seconds())
+		candidates = append(candidates, sc)
+		weights = append(weights, w)
+		total += w
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	// random rather than always the fastest so every server keeps being
+	// measured and clients don't pile on the same one.
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i]
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+// nextAny round-robins over every server, nil when none is ready.
+func (p *Picker) nextAny() balancer.SubConn {
+	readers := len(p.followers) + len(p.nonvoters)
+	servers := readers
+	if p.leader != nil {
+		servers++
+	}
+	if servers == 0 {
+		return nil
+	}
 	cur := atomic.AddUint64(&p.current, uint64(1))
-	l := uint64(len(p.followers))
-	idx := int(cur % l)
-	return p.followers[idx]
+	idx := int(cur % uint64(servers))
+	if idx == readers {
+		return p.leader
+	}
+	return p.reader(idx)
+}
+
+func (p *Picker) reader(idx int) balancer.SubConn {
+	if idx < len(p.followers) {
+		return p.followers[idx]
+	}
+	return p.nonvoters[idx-len(p.followers)]
+}
+
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+// Build gives every connection its own picker, they keep the state of the
+// servers of their connection.
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(Name, &Picker{cc: cc}, base.Config{}).Build(cc, opts)
 }
 
 func init() {
-	balancer.Register(
-		base.NewBalancerBuilder(Name, &Picker{}, base.Config{}),
-	)
+	balancer.Register(builder{})
 }
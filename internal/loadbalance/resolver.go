@@ -2,30 +2,56 @@ package loadbalance
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
 	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/logging"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
-	"google.golang.org/protobuf/types/known/emptypb"
-	"os"
-
-	"log"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"math/rand"
+	"net"
+	"slices"
 	"sync"
+	"time"
 )
 
 const Name = "dhangkanna"
 
 type Resolver struct {
+	// DialOptions are added to the connection used to list the servers.
+	DialOptions []grpc.DialOption
+	// Logger receives the resolver logs, nil uses slog.Default.
+	Logger *slog.Logger
+
 	mu            sync.Mutex
 	clientConn    resolver.ClientConn
+	dialOpts      []grpc.DialOption
 	resolverConn  *grpc.ClientConn
+	resolverAddr  string
 	serviceConfig *serviceconfig.ParseResult
-	logger        *log.Logger
+	logger        *slog.Logger
+	cancel        context.CancelFunc
+	// servers are the addresses of the last resolution, the resolver
+	// lists the servers through one of them when its own goes away.
+	servers []string
+	// closed keeps failover from replacing the conn Close closed.
+	closed bool
 }
 
+const (
+	// pollInterval is how often the servers are listed while they can't
+	// be watched, jittered so clients don't poll in lockstep.
+	pollInterval = 5 * time.Second
+	// resolveTimeout bounds listing the servers through a single server.
+	resolveTimeout = 2 * time.Second
+)
+
 var _ resolver.Builder = (*Resolver)(nil)
 
 func (r *Resolver) Build(
@@ -33,28 +59,32 @@ func (r *Resolver) Build(
 	cc resolver.ClientConn,
 	opts resolver.BuildOptions,
 ) (resolver.Resolver, error) {
-	r.logger = log.New(os.Stdout, "resolver: ", log.LstdFlags|log.Lshortfile)
+	r.logger = logging.Component(r.Logger, "resolver")
 	r.clientConn = cc
 	var dialOpts []grpc.DialOption
-	dialOpts = append(
-		dialOpts,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
 	if opts.DialCreds != nil {
 		dialOpts = append(
 			dialOpts,
 			grpc.WithTransportCredentials(opts.DialCreds),
 		)
+	} else {
+		dialOpts = append(
+			dialOpts,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
 	}
-	r.serviceConfig = r.clientConn.ParseServiceConfig(
-		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
-	)
+	r.dialOpts = append(dialOpts, r.DialOptions...)
+	r.serviceConfig = r.clientConn.ParseServiceConfig(serviceConfig())
 	var err error
-	r.resolverConn, err = grpc.Dial(target.Endpoint(), dialOpts...)
+	r.resolverAddr = target.Endpoint()
+	r.resolverConn, err = grpc.Dial(r.resolverAddr, r.dialOpts...)
 	if err != nil {
 		return nil, err
 	}
 	r.ResolveNow(resolver.ResolveNowOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.watch(ctx)
 	return r, nil
 }
 
@@ -69,34 +99,193 @@ func init() {
 var _ resolver.Resolver = (*Resolver)(nil)
 
 func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.mu.Lock()
+	conn, addr := r.resolverConn, r.resolverAddr
+	known := slices.Clone(r.servers)
+	r.mu.Unlock()
+	if conn == nil {
+		// not built yet, Build resolves once it is.
+		return
+	}
+	servers, err := getServers(conn)
+	if err != nil {
+		r.logger.Error("failed to resolve servers", "addr", addr, "error", err)
+		if servers, err = r.failover(addr, known); err != nil {
+			return
+		}
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	client := api.NewGameServiceClient(r.resolverConn)
-	// get cluster and then set on cc attributes
-	ctx := context.Background()
-	res, err := client.GetServers(ctx, &emptypb.Empty{})
+	r.update(servers)
+}
+
+func getServers(conn *grpc.ClientConn) ([]*dhangkannav1.Server, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	return ListServers(ctx, conn)
+}
+
+// ListServers lists the servers of the cluster through conn, servers older
+// than dhangkanna.v1 are asked through game.GameService/GetServers.
+func ListServers(ctx context.Context, conn grpc.ClientConnInterface) ([]*dhangkannav1.Server, error) {
+	res, err := dhangkannav1.NewGameServiceClient(conn).ListServers(ctx, &dhangkannav1.ListServersRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return legacyServers(ctx, conn)
+	}
 	if err != nil {
-		r.logger.Printf("failed to resolve server: %v\n", err)
-		return
+		return nil, err
 	}
+	return res.Servers, nil
+}
+
+func legacyServers(ctx context.Context, conn grpc.ClientConnInterface) ([]*dhangkannav1.Server, error) {
+	res, err := api.NewGameServiceClient(conn).GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]*dhangkannav1.Server, 0, len(res.Servers))
+	for _, srv := range res.Servers {
+		servers = append(servers, &dhangkannav1.Server{
+			Id:         srv.Id,
+			RpcAddr:    srv.RpcAddr,
+			IsLeader:   srv.IsLeader,
+			IsNonvoter: srv.IsNonvoter,
+		})
+	}
+	return servers, nil
+}
+
+// failover lists the servers through the known servers other than from,
+// the first one answering replaces from as the server the resolver lists
+// them through. It dials without r.mu held, a call failing over at the
+// same time may have replaced from already.
+func (r *Resolver) failover(from string, known []string) ([]*dhangkannav1.Server, error) {
+	err := errors.New("no other server known")
+	for _, addr := range known {
+		if addr == from {
+			continue
+		}
+		var conn *grpc.ClientConn
+		conn, err = grpc.Dial(addr, r.dialOpts...)
+		if err != nil {
+			continue
+		}
+		var servers []*dhangkannav1.Server
+		servers, err = getServers(conn)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		r.mu.Lock()
+		old := conn
+		if !r.closed && r.resolverAddr == from {
+			r.logger.Info("resolving servers through another server", "from", from, "to", addr)
+			old, r.resolverConn, r.resolverAddr = r.resolverConn, conn, addr
+		}
+		r.mu.Unlock()
+		if err := old.Close(); err != nil {
+			r.logger.Warn("failed to close conn", "error", err)
+		}
+		return servers, nil
+	}
+	return nil, err
+}
+
+// watch keeps the servers up to date through WatchServers, and polls them
+// while the stream is down or the server doesn't implement it.
+func (r *Resolver) watch(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		client := dhangkannav1.NewGameServiceClient(r.resolverConn)
+		addr := r.resolverAddr
+		r.mu.Unlock()
+		err := r.watchServers(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			r.logger.Debug("servers can't be watched, polling them", "error", err)
+		} else {
+			r.logger.Warn("watching servers, polling them", "error", err)
+		}
+		r.ResolveNow(resolver.ResolveNowOptions{})
+		r.mu.Lock()
+		failedOver := r.resolverAddr != addr
+		r.mu.Unlock()
+		if failedOver {
+			// watch through the server that replaced the one that failed.
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(pollInterval)):
+		}
+	}
+}
+
+func (r *Resolver) watchServers(ctx context.Context, client dhangkannav1.GameServiceClient) error {
+	stream, err := client.WatchServers(ctx, &dhangkannav1.WatchServersRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.update(res.Servers)
+		r.mu.Unlock()
+	}
+}
+
+// update hands the servers to the balancer, r.mu must be held.
+func (r *Resolver) update(servers []*dhangkannav1.Server) {
 	var addrs []resolver.Address
-	for _, server := range res.Servers {
+	r.servers = r.servers[:0]
+	for _, server := range servers {
+		r.servers = append(r.servers, server.RpcAddr)
+		// verify every server against its own host rather than the one
+		// of the dial target.
+		host, _, err := net.SplitHostPort(server.RpcAddr)
+		if err != nil {
+			r.logger.Warn("invalid server address", "rpc_addr", server.RpcAddr, "error", err)
+			continue
+		}
 		addrs = append(addrs, resolver.Address{
-			Addr: server.RpcAddr,
+			Addr:       server.RpcAddr,
+			ServerName: host,
 			Attributes: attributes.New(
 				"is_leader",
 				server.IsLeader,
+			).WithValue(
+				"is_nonvoter",
+				server.IsNonvoter,
 			),
 		})
 	}
-	r.clientConn.UpdateState(resolver.State{
+	if err := r.clientConn.UpdateState(resolver.State{
 		Addresses:     addrs,
 		ServiceConfig: r.serviceConfig,
-	})
+	}); err != nil {
+		r.logger.Debug("updating state", "error", err)
+	}
+}
+
+// jitter spreads d over [d/2, 3d/2).
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
 }
 
 func (r *Resolver) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
 	if err := r.resolverConn.Close(); err != nil {
-		r.logger.Printf("failed to close conn: %v\n", err)
+		r.logger.Error("failed to close conn", "error", err)
 	}
 }
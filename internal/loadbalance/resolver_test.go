@@ -0,0 +1,60 @@
+package loadbalance
+
+import (
+	"context"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"net"
+	"testing"
+)
+
+// legacyServer only serves game.GameService, like the backends before
+// dhangkanna.v1.
+type legacyServer struct {
+	api.UnimplementedGameServiceServer
+}
+
+func (legacyServer) GetServers(context.Context, *api.GetServersRequest) (*api.GetServersResponse, error) {
+	return &api.GetServersResponse{Servers: []*api.Server{
+		{Id: "node1", RpcAddr: "127.0.0.1:8400", IsLeader: true},
+		{Id: "node2", RpcAddr: "127.0.0.1:8401", IsNonvoter: true},
+	}}, nil
+}
+
+func TestListServersFallsBackOnLegacy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	api.RegisterGameServiceServer(srv, legacyServer{})
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	servers, err := ListServers(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*dhangkannav1.Server{
+		{Id: "node1", RpcAddr: "127.0.0.1:8400", IsLeader: true},
+		{Id: "node2", RpcAddr: "127.0.0.1:8401", IsNonvoter: true},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("got %d servers, want %d", len(servers), len(want))
+	}
+	for i, got := range servers {
+		if got.Id != want[i].Id || got.RpcAddr != want[i].RpcAddr || got.IsLeader != want[i].IsLeader || got.IsNonvoter != want[i].IsNonvoter {
+			t.Errorf("server %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package loadbalance
+
+import (
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"strings"
+	"sync"
+)
+
+// Route tells the picker which servers can serve a method.
+type Route int
+
+const (
+	// LeaderOnly methods change the game or the cluster, only the leader
+	// can apply them.
+	LeaderOnly Route = iota
+	// FollowerPreferred methods are reads spread over the followers and
+	// nonvoters, the leader serves them when there are none or when the
+	// client asks for Strong consistency.
+	FollowerPreferred
+	// Any server can serve the method, including the leader.
+	Any
+)
+
+func (r Route) String() string {
+	switch r {
+	case LeaderOnly:
+		return "leader-only"
+	case FollowerPreferred:
+		return "follower-preferred"
+	case Any:
+		return "any"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	routesMu sync.RWMutex
+	// methodRoutes holds the routes of RegisterRoute and the ones read from
+	// the route options of the protos.
+	methodRoutes = map[string]Route{}
+)
+
+// the health service comes with grpc, its protos have no route options.
+func init() {
+	RegisterRoute(healthpb.Health_Check_FullMethodName, Any)
+	RegisterRoute(healthpb.Health_Watch_FullMethodName, Any)
+}
+
+// RegisterRoute routes method, it is for the services whose protos can't
+// carry the dhangkanna.v1.route option, the others are routed by it.
+func RegisterRoute(method string, route Route) {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	methodRoutes[method] = route
+}
+
+func routeOf(method string) Route {
+	routesMu.RLock()
+	route, ok := methodRoutes[method]
+	routesMu.RUnlock()
+	if ok {
+		return route
+	}
+	route = LeaderOnly
+	if md := methodDescriptor(method); md != nil {
+		route = optionRoute(md)
+	}
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	// RegisterRoute wins over the option when it ran in between.
+	if registered, ok := methodRoutes[method]; ok {
+		return registered
+	}
+	methodRoutes[method] = route
+	return route
+}
+
+// methodDescriptor finds the proto of a /package.Service/Method in the
+// registered files, nil when the method isn't known.
+func methodDescriptor(method string) protoreflect.MethodDescriptor {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return nil
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	return sd.Methods().ByName(protoreflect.Name(name))
+}
+
+// optionRoute reads the route option of a method, falling back on the
+// default route of its service and then on the leader.
+func optionRoute(md protoreflect.MethodDescriptor) Route {
+	if opts := md.Options(); proto.HasExtension(opts, dhangkannav1.E_Route) {
+		return protoRoute(proto.GetExtension(opts, dhangkannav1.E_Route).(dhangkannav1.Route))
+	}
+	sd := md.Parent().(protoreflect.ServiceDescriptor)
+	if opts := sd.Options(); proto.HasExtension(opts, dhangkannav1.E_DefaultRoute) {
+		return protoRoute(proto.GetExtension(opts, dhangkannav1.E_DefaultRoute).(dhangkannav1.Route))
+	}
+	return LeaderOnly
+}
+
+func protoRoute(r dhangkannav1.Route) Route {
+	switch r {
+	case dhangkannav1.Route_ROUTE_FOLLOWER_PREFERRED:
+		return FollowerPreferred
+	case dhangkannav1.Route_ROUTE_ANY:
+		return Any
+	default:
+		return LeaderOnly
+	}
+}
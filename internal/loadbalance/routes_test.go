@@ -0,0 +1,45 @@
+package loadbalance
+
+import (
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+)
+
+func TestRouteOf(t *testing.T) {
+	tests := []struct {
+		method string
+		want   Route
+	}{
+		{dhangkannav1.GameService_GetGame_FullMethodName, FollowerPreferred},
+		{dhangkannav1.GameService_Guess_FullMethodName, LeaderOnly},
+		{dhangkannav1.GameService_ResetGame_FullMethodName, LeaderOnly},
+		{dhangkannav1.GameService_ListServers_FullMethodName, Any},
+		{dhangkannav1.GameService_WatchServers_FullMethodName, Any},
+		{api.GameService_Receive_FullMethodName, FollowerPreferred},
+		{api.GameService_Send_FullMethodName, LeaderOnly},
+		{api.GameService_GetServers_FullMethodName, Any},
+		// the default route of the service.
+		{api.AdminService_Snapshot_FullMethodName, LeaderOnly},
+		// RegisterRoute, the health protos have no options.
+		{healthpb.Health_Check_FullMethodName, Any},
+		// unknown methods go to the leader.
+		{"/unknown.Service/Method", LeaderOnly},
+		{"malformed", LeaderOnly},
+	}
+	for _, tt := range tests {
+		if got := routeOf(tt.method); got != tt.want {
+			t.Errorf("routeOf(%q) = %s, want %s", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterRouteOverridesOption(t *testing.T) {
+	method := dhangkannav1.GameService_ListServers_FullMethodName
+	RegisterRoute(method, LeaderOnly)
+	defer RegisterRoute(method, Any)
+	if got := routeOf(method); got != LeaderOnly {
+		t.Errorf("routeOf(%q) = %s, want %s", method, got, LeaderOnly)
+	}
+}
@@ -0,0 +1,102 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"sort"
+	"strings"
+)
+
+// retryPolicy retries calls on servers going away, the picker re-resolves
+// the servers before the next attempt.
+func retryPolicy(codes ...string) map[string]any {
+	return map[string]any{
+		"maxAttempts":          4,
+		"initialBackoff":       "0.1s",
+		"maxBackoff":           "1s",
+		"backoffMultiplier":    2,
+		"retryableStatusCodes": append([]string{"UNAVAILABLE"}, codes...),
+	}
+}
+
+// serviceConfig selects the picker and retries the unary methods it can
+// route to followers, those are the ones safe to call twice, the writes
+// are retried by the Go client instead so the attempts don't multiply.
+// FollowerPreferred reads are also retried on a follower asked for a
+// strong read while the picker catches up with a new leader. The retries
+// stay within the deadline of the call.
+//
+// It is built once per connection by the resolver, routes registered after
+// the connection was dialed get no retries on it.
+func serviceConfig() string {
+	var readers, others []map[string]string
+	for _, method := range retriedMethods() {
+		service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+		if !ok {
+			continue
+		}
+		config := map[string]string{"service": service, "method": name}
+		if routeOf(method) == FollowerPreferred {
+			readers = append(readers, config)
+		} else {
+			others = append(others, config)
+		}
+	}
+	var methodConfig []any
+	if len(readers) > 0 {
+		methodConfig = append(methodConfig, map[string]any{
+			"name":        readers,
+			"retryPolicy": retryPolicy("FAILED_PRECONDITION"),
+		})
+	}
+	if len(others) > 0 {
+		methodConfig = append(methodConfig, map[string]any{
+			"name":        others,
+			"retryPolicy": retryPolicy(),
+		})
+	}
+	b, err := json.Marshal(map[string]any{
+		"loadBalancingConfig": []any{map[string]any{Name: map[string]any{}}},
+		"methodConfig":        methodConfig,
+	})
+	if err != nil {
+		// the config is made of plain maps and strings.
+		panic(err)
+	}
+	return string(b)
+}
+
+// retriedMethods lists the unary methods of RegisterRoute and of the
+// registered protos that aren't LeaderOnly. Streams are left out, a retry
+// would replay them from the start, and so are the methods without a
+// registered proto since they could be streams.
+func retriedMethods() []string {
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				md := methods.Get(j)
+				routeOf(fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name()))
+			}
+		}
+		return true
+	})
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	var methods []string
+	for method, route := range methodRoutes {
+		if route == LeaderOnly {
+			continue
+		}
+		md := methodDescriptor(method)
+		if md == nil || md.IsStreamingClient() || md.IsStreamingServer() {
+			continue
+		}
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,50 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestServiceConfigRetries(t *testing.T) {
+	var config struct {
+		MethodConfig []struct {
+			Name []struct {
+				Service string
+				Method  string
+			}
+			RetryPolicy struct {
+				RetryableStatusCodes []string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(serviceConfig()), &config); err != nil {
+		t.Fatal(err)
+	}
+	codes := map[string][]string{}
+	for _, mc := range config.MethodConfig {
+		for _, name := range mc.Name {
+			codes["/"+name.Service+"/"+name.Method] = mc.RetryPolicy.RetryableStatusCodes
+		}
+	}
+
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{"/dhangkanna.v1.GameService/GetGame", []string{"UNAVAILABLE", "FAILED_PRECONDITION"}},
+		{"/dhangkanna.v1.GameService/ListServers", []string{"UNAVAILABLE"}},
+		{"/grpc.health.v1.Health/Check", []string{"UNAVAILABLE"}},
+		// writes are retried by the Go client.
+		{"/dhangkanna.v1.GameService/Guess", nil},
+		{"/dhangkanna.v1.GameService/ResetGame", nil},
+		// streams would be replayed from the start.
+		{"/dhangkanna.v1.GameService/WatchServers", nil},
+		{"/grpc.health.v1.Health/Watch", nil},
+	}
+	for _, tt := range tests {
+		if got := codes[tt.method]; !slices.Equal(got, tt.want) {
+			t.Errorf("%s retries on %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
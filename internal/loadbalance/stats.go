@@ -0,0 +1,95 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/balancer"
+	"strconv"
+	"time"
+)
+
+// AppliedIndexKey is the trailer servers report their raft applied index
+// in, the picker compares them to find lagging followers.
+const AppliedIndexKey = "dhangkanna-applied-index"
+
+const (
+	// maxReplicationLag is how many entries a follower can have left to
+	// apply, compared to the most up to date server seen, and still serve
+	// reads.
+	maxReplicationLag = 16
+	// appliedTTL is how long the applied index of a server is trusted, a
+	// lagging follower gets reads again after it so its next answer can
+	// show it caught up.
+	appliedTTL = 5 * time.Second
+	// latencyDecay weights the last call in the moving average of the
+	// latency of a server.
+	latencyDecay = 0.3
+	// minLatency caps the weight of the fastest servers, and is the
+	// latency assumed for servers that didn't answer yet.
+	minLatency = time.Millisecond
+)
+
+// subConnStats is what the picker learned about a server from the calls
+// it sent there.
+type subConnStats struct {
+	// latency is a moving average, zero until a call came back.
+	latency time.Duration
+	applied uint64
+	// appliedAt is when applied was reported.
+	appliedAt time.Time
+}
+
+// observe records a call that took elapsed on sc.
+func (p *Picker) observe(sc balancer.SubConn, elapsed time.Duration, info balancer.DoneInfo) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	st, ok := p.stats[sc]
+	if !ok {
+		st = &subConnStats{}
+		p.stats[sc] = st
+	}
+	if st.latency == 0 {
+		st.latency = elapsed
+	} else {
+		st.latency += time.Duration(latencyDecay * float64(elapsed-st.latency))
+	}
+	values := info.Trailer.Get(AppliedIndexKey)
+	if len(values) == 0 {
+		return
+	}
+	applied, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return
+	}
+	st.applied, st.appliedAt = applied, time.Now()
+	if applied > p.maxApplied {
+		p.maxApplied = applied
+	}
+}
+
+// lagging reports whether sc is too far behind to serve reads, servers
+// that didn't answer yet or not for appliedTTL aren't. p.statsMu must be
+// held.
+func (p *Picker) lagging(sc balancer.SubConn) bool {
+	st, ok := p.stats[sc]
+	if !ok || st.applied == 0 || time.Since(st.appliedAt) > appliedTTL {
+		return false
+	}
+	return p.maxApplied-st.applied > maxReplicationLag
+}
+
+// latency of sc, p.statsMu must be held.
+func (p *Picker) latency(sc balancer.SubConn) time.Duration {
+	if st, ok := p.stats[sc]; ok {
+		return st.latency
+	}
+	return 0
+}
+
+// resetMaxApplied recomputes maxApplied from the servers left after the
+// servers changed, the ones gone may have been ahead of a restored or
+// replaced cluster for good. p.statsMu must be held.
+func (p *Picker) resetMaxApplied() {
+	p.maxApplied = 0
+	for _, st := range p.stats {
+		p.maxApplied = max(p.maxApplied, st.applied)
+	}
+}
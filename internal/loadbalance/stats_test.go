@@ -0,0 +1,78 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func readySCs(leader balancer.SubConn, followers ...balancer.SubConn) map[balancer.SubConn]base.SubConnInfo {
+	ready := map[balancer.SubConn]base.SubConnInfo{}
+	add := func(sc balancer.SubConn, isLeader bool) {
+		ready[sc] = base.SubConnInfo{Address: resolver.Address{
+			Attributes: attributes.New("is_leader", isLeader),
+		}}
+	}
+	add(leader, true)
+	for _, sc := range followers {
+		add(sc, false)
+	}
+	return ready
+}
+
+func applied(index uint64) balancer.DoneInfo {
+	return balancer.DoneInfo{Trailer: metadata.Pairs(AppliedIndexKey, strconv.FormatUint(index, 10))}
+}
+
+func TestLaggingExpires(t *testing.T) {
+	leader, follower := &fakeSubConn{name: "leader"}, &fakeSubConn{name: "follower"}
+	p := &Picker{}
+	p.Build(base.PickerBuildInfo{ReadySCs: readySCs(leader, follower)})
+	p.observe(leader, time.Millisecond, applied(100))
+	p.observe(follower, time.Millisecond, applied(10))
+
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	if !p.lagging(follower) {
+		t.Fatal("follower 90 entries behind isn't lagging")
+	}
+	p.stats[follower].appliedAt = time.Now().Add(-appliedTTL - time.Second)
+	if p.lagging(follower) {
+		t.Fatal("follower is still lagging after its applied index expired")
+	}
+}
+
+func TestBuildResetsMaxApplied(t *testing.T) {
+	leader, follower := &fakeSubConn{name: "leader"}, &fakeSubConn{name: "follower"}
+	p := &Picker{}
+	p.Build(base.PickerBuildInfo{ReadySCs: readySCs(leader, follower)})
+	p.observe(leader, time.Millisecond, applied(100))
+	p.observe(follower, time.Millisecond, applied(10))
+
+	// the same servers keep what they reported.
+	p.Build(base.PickerBuildInfo{ReadySCs: readySCs(leader, follower)})
+	if p.maxApplied != 100 {
+		t.Fatalf("maxApplied = %d, want 100", p.maxApplied)
+	}
+
+	// a new leader replaces the one that was ahead.
+	p.Build(base.PickerBuildInfo{ReadySCs: readySCs(&fakeSubConn{name: "new"}, follower)})
+	if p.maxApplied != 10 {
+		t.Fatalf("maxApplied = %d, want 10", p.maxApplied)
+	}
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	if p.lagging(follower) {
+		t.Fatal("follower is lagging behind a server that is gone")
+	}
+}
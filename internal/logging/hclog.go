@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/go-hclog"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// HCLog adapts logger to the hclog interface used by raft, name becomes
+// the component of its logs. A nil logger uses slog.Default.
+func HCLog(logger *slog.Logger, name string) hclog.Logger {
+	return newHCLogger(orDefault(logger), name, nil)
+}
+
+type hcLogger struct {
+	// base has the implied args but no component, so renaming the logger
+	// doesn't stack components.
+	base    *slog.Logger
+	logger  *slog.Logger
+	name    string
+	implied []any
+}
+
+func newHCLogger(base *slog.Logger, name string, implied []any) *hcLogger {
+	return &hcLogger{
+		base:    base,
+		logger:  base.With("component", name),
+		name:    name,
+		implied: implied,
+	}
+}
+
+var _ hclog.Logger = (*hcLogger)(nil)
+
+func slogLevel(level hclog.Level) slog.Level {
+	switch level {
+	case hclog.Trace, hclog.Debug:
+		return slog.LevelDebug
+	case hclog.Warn:
+		return slog.LevelWarn
+	case hclog.Error:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (l *hcLogger) Log(level hclog.Level, msg string, args ...any) {
+	if level == hclog.Off {
+		return
+	}
+	l.logger.Log(context.Background(), slogLevel(level), msg, formatArgs(args)...)
+}
+
+// formatArgs renders the values raft formats lazily through hclog, such as
+// hclog.Fmt, slog would otherwise print their raw structure.
+func formatArgs(args []any) []any {
+	formatted := make([]any, len(args))
+	for i, arg := range args {
+		if i%2 == 0 {
+			formatted[i] = arg
+			continue
+		}
+		switch v := arg.(type) {
+		case hclog.Format:
+			if len(v) > 0 {
+				if format, ok := v[0].(string); ok {
+					arg = fmt.Sprintf(format, v[1:]...)
+				}
+			}
+		case error:
+			arg = v.Error()
+		case fmt.Stringer:
+			arg = v.String()
+		}
+		formatted[i] = arg
+	}
+	return formatted
+}
+
+func (l *hcLogger) Trace(msg string, args ...any) { l.Log(hclog.Trace, msg, args...) }
+func (l *hcLogger) Debug(msg string, args ...any) { l.Log(hclog.Debug, msg, args...) }
+func (l *hcLogger) Info(msg string, args ...any)  { l.Log(hclog.Info, msg, args...) }
+func (l *hcLogger) Warn(msg string, args ...any)  { l.Log(hclog.Warn, msg, args...) }
+func (l *hcLogger) Error(msg string, args ...any) { l.Log(hclog.Error, msg, args...) }
+
+func (l *hcLogger) enabled(level hclog.Level) bool {
+	return l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l *hcLogger) IsTrace() bool { return l.enabled(hclog.Trace) }
+func (l *hcLogger) IsDebug() bool { return l.enabled(hclog.Debug) }
+func (l *hcLogger) IsInfo() bool  { return l.enabled(hclog.Info) }
+func (l *hcLogger) IsWarn() bool  { return l.enabled(hclog.Warn) }
+func (l *hcLogger) IsError() bool { return l.enabled(hclog.Error) }
+
+func (l *hcLogger) ImpliedArgs() []any {
+	return l.implied
+}
+
+func (l *hcLogger) With(args ...any) hclog.Logger {
+	return newHCLogger(
+		l.base.With(args...),
+		l.name,
+		append(append([]any{}, l.implied...), args...),
+	)
+}
+
+func (l *hcLogger) Name() string {
+	return l.name
+}
+
+func (l *hcLogger) Named(name string) hclog.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return l.ResetNamed(name)
+}
+
+func (l *hcLogger) ResetNamed(name string) hclog.Logger {
+	return newHCLogger(l.base, name, l.implied)
+}
+
+// SetLevel is a no-op, the level is owned by the slog handler.
+func (l *hcLogger) SetLevel(hclog.Level) {}
+
+func (l *hcLogger) GetLevel() hclog.Level {
+	for _, level := range []hclog.Level{hclog.Debug, hclog.Info, hclog.Warn, hclog.Error} {
+		if l.enabled(level) {
+			return level
+		}
+	}
+	return hclog.Off
+}
+
+func (l *hcLogger) StandardLogger(*hclog.StandardLoggerOptions) *log.Logger {
+	return StdLogger(l.logger)
+}
+
+func (l *hcLogger) StandardWriter(*hclog.StandardLoggerOptions) io.Writer {
+	return &levelWriter{logger: l.logger}
+}
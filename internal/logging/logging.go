@@ -0,0 +1,100 @@
+// Package logging builds the structured logger shared by every component
+// and adapts it to the loggers raft, serf and memberlist expect.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Config struct {
+	// Level is debug, info, warn or error.
+	Level  string
+	Format string
+	Output io.Writer
+}
+
+func New(config Config) (*slog.Logger, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch config.Format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(config.Output, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(config.Output, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// Component returns the logger used by a component, nil loggers fall back
+// to slog.Default so packages can be used without wiring one.
+func Component(logger *slog.Logger, name string) *slog.Logger {
+	return orDefault(logger).With("component", name)
+}
+
+func orDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// StdLogger adapts logger for libraries taking a *log.Logger, serf and
+// memberlist prefix their lines with a level such as "[WARN] " which is
+// turned into the matching slog level.
+func StdLogger(logger *slog.Logger) *log.Logger {
+	return log.New(&levelWriter{logger: logger}, "", 0)
+}
+
+type levelWriter struct {
+	logger *slog.Logger
+}
+
+var levelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"[TRACE] ", slog.LevelDebug},
+	{"[DEBUG] ", slog.LevelDebug},
+	{"[INFO] ", slog.LevelInfo},
+	{"[WARN] ", slog.LevelWarn},
+	{"[ERR] ", slog.LevelError},
+	{"[ERROR] ", slog.LevelError},
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	level := slog.LevelInfo
+	for _, l := range levelPrefixes {
+		if rest, ok := strings.CutPrefix(msg, l.prefix); ok {
+			msg, level = rest, l.level
+			break
+		}
+	}
+	w.logger.Log(context.Background(), level, msg)
+	return len(p), nil
+}
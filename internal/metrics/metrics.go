@@ -0,0 +1,103 @@
+// Package metrics exposes node metrics in the Prometheus format.
+package metrics
+
+import (
+	gometrics "github.com/armon/go-metrics"
+	gometricsprom "github.com/armon/go-metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+)
+
+const namespace = "dhangkanna"
+
+var (
+	RPCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "gRPC requests handled, by method and status code.",
+	}, []string{"method", "code"})
+	RPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent handling gRPC requests, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	// ApplyDuration is measured on the leader from Raft.Apply until the
+	// entry is committed and applied to its FSM.
+	ApplyDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "raft",
+		Name:      "apply_duration_seconds",
+		Help:      "Time for a raft entry to be committed and applied on the leader.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	Guesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "game",
+		Name:      "guesses_total",
+		Help:      "Guesses handled by this node.",
+	})
+	Wins = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "game",
+		Name:      "wins_total",
+		Help:      "Games won by a guess handled by this node.",
+	})
+	Losses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "game",
+		Name:      "losses_total",
+		Help:      "Games lost by a guess handled by this node.",
+	})
+)
+
+var setupOnce sync.Once
+
+func init() {
+	prometheus.MustRegister(
+		RPCRequests,
+		RPCDuration,
+		ApplyDuration,
+		Guesses,
+		Wins,
+		Losses,
+	)
+}
+
+// Setup forwards the metrics raft and serf emit through go-metrics, commit
+// time and FSM apply time among them, to the Prometheus registry. It is
+// safe to call more than once.
+func Setup() error {
+	var err error
+	setupOnce.Do(func() {
+		var sink *gometricsprom.PrometheusSink
+		sink, err = gometricsprom.NewPrometheusSink()
+		if err != nil {
+			return
+		}
+		conf := gometrics.DefaultConfig(namespace)
+		conf.EnableHostname = false
+		conf.EnableRuntimeMetrics = false
+		_, err = gometrics.NewGlobal(conf, sink)
+	})
+	return err
+}
+
+// Register adds a collector to the registry served by Handler, collectors
+// that are already registered are ignored.
+func Register(c prometheus.Collector) error {
+	if err := prometheus.Register(c); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+// raftGauges are the numeric keys of Raft.Stats exported as gauges.
+var raftGauges = []string{
+	"term",
+	"last_log_index",
+	"last_log_term",
+	"commit_index",
+	"applied_index",
+	"fsm_pending",
+	"last_snapshot_index",
+	"last_snapshot_term",
+	"latest_configuration_index",
+	"num_peers",
+}
+
+var raftStates = []raft.RaftState{
+	raft.Follower,
+	raft.Candidate,
+	raft.Leader,
+	raft.Shutdown,
+}
+
+type raftCollector struct {
+	raft        *raft.Raft
+	gauges      map[string]*prometheus.Desc
+	state       *prometheus.Desc
+	lastContact *prometheus.Desc
+}
+
+// NewRaftCollector exports Raft.Stats, it reads them on every scrape.
+func NewRaftCollector(r *raft.Raft) prometheus.Collector {
+	c := &raftCollector{
+		raft:   r,
+		gauges: make(map[string]*prometheus.Desc, len(raftGauges)),
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "raft", "state"),
+			"1 for the current raft state of this node, 0 for the others.",
+			[]string{"state"},
+			nil,
+		),
+		lastContact: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "raft", "last_contact_seconds"),
+			"Time since this node last heard from the leader.",
+			nil,
+			nil,
+		),
+	}
+	for _, key := range raftGauges {
+		c.gauges[key] = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "raft", key),
+			"Raft "+key+" as reported by raft stats.",
+			nil,
+			nil,
+		)
+	}
+	return c
+}
+
+func (c *raftCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.gauges {
+		ch <- desc
+	}
+	ch <- c.state
+	ch <- c.lastContact
+}
+
+func (c *raftCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.raft.Stats()
+	for key, desc := range c.gauges {
+		v, err := strconv.ParseFloat(stats[key], 64)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+	current := c.raft.State()
+	for _, state := range raftStates {
+		var v float64
+		if state == current {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, v, state.String())
+	}
+	// the leader reports 0 and a node that never heard from one "never".
+	switch lastContact := stats["last_contact"]; lastContact {
+	case "never":
+	case "0":
+		ch <- prometheus.MustNewConstMetric(c.lastContact, prometheus.GaugeValue, 0)
+	default:
+		if d, err := time.ParseDuration(lastContact); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.lastContact, prometheus.GaugeValue, d.Seconds())
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"github.com/hashicorp/serf/serf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var memberStatuses = []serf.MemberStatus{
+	serf.StatusAlive,
+	serf.StatusLeaving,
+	serf.StatusLeft,
+	serf.StatusFailed,
+}
+
+type serfCollector struct {
+	members func() []serf.Member
+	desc    *prometheus.Desc
+}
+
+// NewSerfCollector counts the members returned by members by status.
+func NewSerfCollector(members func() []serf.Member) prometheus.Collector {
+	return &serfCollector{
+		members: members,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "serf", "members"),
+			"Serf members known to this node, by status.",
+			[]string{"status"},
+			nil,
+		),
+	}
+}
+
+func (c *serfCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *serfCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[serf.MemberStatus]int)
+	for _, member := range c.members() {
+		counts[member.Status]++
+	}
+	for _, status := range memberStatuses {
+		ch <- prometheus.MustNewConstMetric(
+			c.desc,
+			prometheus.GaugeValue,
+			float64(counts[status]),
+			status.String(),
+		)
+	}
+}
@@ -0,0 +1,99 @@
+// Package ratelimit implements token buckets keyed by client.
+package ratelimit
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped,
+// a full bucket behaves exactly like a missing one.
+const sweepInterval = time.Minute
+
+// Limiter allows every key Rate events per second on average with bursts of
+// up to Burst events.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key, when it is empty it returns
+// false and how long to wait before the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// PlayerKey is the metadata a frontend, calling on behalf of all of its
+// players, names the player of a call in.
+const PlayerKey = "dhangkanna-player"
+
+// WithPlayer names the player ctx calls for, servers only trust it from
+// callers with the moderator role.
+func WithPlayer(ctx context.Context, player string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, PlayerKey, player)
+}
+
+// IncomingPlayer is the player named by the caller, if any.
+func IncomingPlayer(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(PlayerKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
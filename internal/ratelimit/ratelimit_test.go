@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	type step struct {
+		advance   time.Duration
+		key       string
+		wantOK    bool
+		wantRetry time.Duration
+	}
+	steps := []step{
+		// a new key starts with a full burst.
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		// keys don't share buckets.
+		{0, "b", true, 0},
+		// half a token is back after 250ms at 2 per second.
+		{250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+		{250 * time.Millisecond, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		// a bucket never refills beyond the burst.
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		ok, retry := l.Allow(s.key)
+		if ok != s.wantOK || retry != s.wantRetry {
+			t.Fatalf("step %d: got %v, %s, want %v, %s", i, ok, retry, s.wantOK, s.wantRetry)
+		}
+	}
+}
+
+func TestLimiterSweepsFullBuckets(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := New(1, 2)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+	now = now.Add(sweepInterval)
+	// a refilled long ago, b is refilled as well after a minute at 1/s.
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("full bucket a was not swept")
+	}
+	if _, ok := l.buckets["c"]; !ok {
+		t.Fatal("bucket c was swept")
+	}
+}
+
+func TestNewClampsBurst(t *testing.T) {
+	l := New(1, 0)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("a burst of 0 should still allow one event")
+	}
+}
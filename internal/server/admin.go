@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/backup"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+)
+
+var _ api.AdminServiceServer = (*adminServer)(nil)
+
+type adminServer struct {
+	api.UnimplementedAdminServiceServer
+	*Config
+	logger *slog.Logger
+}
+
+func newAdminServer(config *Config) *adminServer {
+	return &adminServer{
+		Config: config,
+		logger: logging.Component(config.Logger, "admin"),
+	}
+}
+
+func (s *adminServer) AddVoter(_ context.Context, req *api.AddServerRequest) (*api.AddServerResponse, error) {
+	if req.Id == "" || req.RpcAddr == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and rpc_addr are required")
+	}
+	s.logger.Info("AddVoter received", "id", req.Id, "rpc_addr", req.RpcAddr)
+	if err := s.Administrator.AddVoter(req.Id, req.RpcAddr); err != nil {
+		return nil, s.raftError(err)
+	}
+	return &api.AddServerResponse{}, nil
+}
+
+func (s *adminServer) AddNonvoter(_ context.Context, req *api.AddServerRequest) (*api.AddServerResponse, error) {
+	if req.Id == "" || req.RpcAddr == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and rpc_addr are required")
+	}
+	s.logger.Info("AddNonvoter received", "id", req.Id, "rpc_addr", req.RpcAddr)
+	if err := s.Administrator.AddNonvoter(req.Id, req.RpcAddr); err != nil {
+		return nil, s.raftError(err)
+	}
+	return &api.AddServerResponse{}, nil
+}
+
+func (s *adminServer) RemoveServer(_ context.Context, req *api.RemoveServerRequest) (*api.RemoveServerResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	s.logger.Info("RemoveServer received", "id", req.Id)
+	if err := s.Administrator.RemoveServer(req.Id); err != nil {
+		return nil, s.raftError(err)
+	}
+	return &api.RemoveServerResponse{}, nil
+}
+
+func (s *adminServer) TransferLeadership(_ context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	s.logger.Info("TransferLeadership received", "id", req.Id)
+	if err := s.Administrator.TransferLeadership(req.Id); err != nil {
+		return nil, s.raftError(err)
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminServer) DemoteVoter(_ context.Context, req *api.DemoteVoterRequest) (*api.DemoteVoterResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	s.logger.Info("DemoteVoter received", "id", req.Id)
+	if err := s.Administrator.DemoteVoter(req.Id); err != nil {
+		return nil, s.raftError(err)
+	}
+	return &api.DemoteVoterResponse{}, nil
+}
+
+func (s *adminServer) Snapshot(_ context.Context, _ *api.SnapshotRequest) (*api.SnapshotMeta, error) {
+	s.logger.Info("Snapshot received")
+	meta, err := s.Administrator.Snapshot()
+	if err != nil {
+		return nil, s.raftError(err)
+	}
+	return meta, nil
+}
+
+func (s *adminServer) ListSnapshots(_ context.Context, _ *api.ListSnapshotsRequest) (*api.ListSnapshotsResponse, error) {
+	snapshots, err := s.Administrator.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	return &api.ListSnapshotsResponse{Snapshots: snapshots}, nil
+}
+
+// snapshotChunkSize keeps every message well below gRPC's 4MB limit.
+const snapshotChunkSize = 64 * 1024
+
+func (s *adminServer) Backup(_ *api.BackupRequest, stream api.AdminService_BackupServer) error {
+	s.logger.Info("Backup received")
+	meta, rc, err := s.Administrator.Backup()
+	if err != nil {
+		return s.raftError(err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	if err := stream.Send(&api.SnapshotChunk{Meta: meta}); err != nil {
+		return err
+	}
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&api.SnapshotChunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.logger.Info("backup completed", "snapshot_id", meta.Id)
+	return nil
+}
+
+func (s *adminServer) Restore(stream api.AdminService_RestoreServer) error {
+	s.logger.Info("Restore received")
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Meta == nil {
+		return status.Error(codes.InvalidArgument, "first chunk must carry the snapshot meta")
+	}
+	r := backup.NewChunkReader(first.Data, func() ([]byte, error) {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return chunk.Data, nil
+	})
+	if err := s.Administrator.Restore(first.Meta, r); err != nil {
+		return s.raftError(err)
+	}
+	s.logger.Info("restore completed", "snapshot_id", first.Meta.Id)
+	return stream.SendAndClose(&api.RestoreResponse{})
+}
+
+func (s *adminServer) ListKeys(_ context.Context, _ *api.ListKeysRequest) (*api.KeyringResponse, error) {
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	return convertKeyResponse(s.Keyring.ListKeys())
+}
+
+func (s *adminServer) InstallKey(_ context.Context, req *api.KeyRequest) (*api.KeyringResponse, error) {
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	s.logger.Info("InstallKey received")
+	return convertKeyResponse(s.Keyring.InstallKey(req.Key))
+}
+
+func (s *adminServer) UseKey(_ context.Context, req *api.KeyRequest) (*api.KeyringResponse, error) {
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	s.logger.Info("UseKey received")
+	return convertKeyResponse(s.Keyring.UseKey(req.Key))
+}
+
+func (s *adminServer) RemoveKey(_ context.Context, req *api.KeyRequest) (*api.KeyringResponse, error) {
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	s.logger.Info("RemoveKey received")
+	return convertKeyResponse(s.Keyring.RemoveKey(req.Key))
+}
+
+// raftError maps the errors of raft to status codes the way the game
+// server does, a follower answers NOT_LEADER with the leader to retry on.
+func (s *adminServer) raftError(err error) error {
+	switch {
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipTransferInProgress):
+		return notLeaderError(s.Game)
+	case errors.Is(err, game.ErrUnknownServer):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, raft.ErrNothingNewToSnapshot):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, raft.ErrLeadershipLost), errors.Is(err, raft.ErrRaftShutdown), errors.Is(err, raft.ErrEnqueueTimeout):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return err
+	}
+}
+
+var errNoKeyring = status.Error(codes.FailedPrecondition, "gossip keyring is not available")
+
+// convertKeyResponse reports partial failures as errors, listing the
+// message of every node that failed.
+func convertKeyResponse(resp *serf.KeyResponse, err error) (*api.KeyringResponse, error) {
+	if err != nil {
+		if resp != nil && len(resp.Messages) > 0 {
+			return nil, status.Errorf(codes.Aborted, "%v: %v", err, resp.Messages)
+		}
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	res := &api.KeyringResponse{
+		Keys:        make(map[string]int32, len(resp.Keys)),
+		PrimaryKeys: make(map[string]int32, len(resp.PrimaryKeys)),
+		NumNodes:    int32(resp.NumNodes),
+		NumResp:     int32(resp.NumResp),
+		NumErr:      int32(resp.NumErr),
+		Messages:    resp.Messages,
+	}
+	for k, v := range resp.Keys {
+		res.Keys[k] = int32(v)
+	}
+	for k, v := range resp.PrimaryKeys {
+		res.PrimaryKeys[k] = int32(v)
+	}
+	return res, nil
+}
+
+type Administrator interface {
+	AddVoter(id, addr string) error
+	AddNonvoter(id, addr string) error
+	RemoveServer(id string) error
+	TransferLeadership(id string) error
+	DemoteVoter(id string) error
+	Snapshot() (*api.SnapshotMeta, error)
+	ListSnapshots() ([]*api.SnapshotMeta, error)
+	Backup() (*api.SnapshotMeta, io.ReadCloser, error)
+	Restore(meta *api.SnapshotMeta, r io.Reader) error
+}
+
+type Keyring interface {
+	ListKeys() (*serf.KeyResponse, error)
+	InstallKey(key string) (*serf.KeyResponse, error)
+	UseKey(key string) (*serf.KeyResponse, error)
+	RemoveKey(key string) (*serf.KeyResponse, error)
+}
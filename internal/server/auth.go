@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// methodRoles is the minimum role needed to call each method, any method
+// missing from here, admin RPCs and future ones alike, requires RoleAdmin.
+var methodRoles = map[string]auth.Role{
+	dhangkannav1.GameService_GetGame_FullMethodName:      auth.RolePlayer,
+	dhangkannav1.GameService_Guess_FullMethodName:        auth.RolePlayer,
+	dhangkannav1.GameService_ListServers_FullMethodName:  auth.RolePlayer,
+	dhangkannav1.GameService_WatchServers_FullMethodName: auth.RolePlayer,
+	dhangkannav1.GameService_ResetGame_FullMethodName:    auth.RoleModerator,
+	api.GameService_Send_FullMethodName:                  auth.RolePlayer,
+	api.GameService_Receive_FullMethodName:               auth.RolePlayer,
+	api.GameService_GetServers_FullMethodName:            auth.RolePlayer,
+	api.GameService_WatchServers_FullMethodName:          auth.RolePlayer,
+	api.GameService_Reset_FullMethodName:                 auth.RoleModerator,
+}
+
+// publicMethods can be called without a token, supervisors probing health
+// shouldn't need credentials.
+var publicMethods = map[string]bool{
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+}
+
+func requiredRole(method string) auth.Role {
+	if role, ok := methodRoles[method]; ok {
+		return role
+	}
+	return auth.RoleAdmin
+}
+
+func authorize(ctx context.Context, authenticator auth.Authenticator, method string) (context.Context, error) {
+	if publicMethods[method] {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+	}
+	id, err := authenticator.Authenticate(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if role := requiredRole(method); id.Role < role {
+		return nil, status.Errorf(
+			codes.PermissionDenied,
+			"%s with role %s can't call %s, it needs %s",
+			id.Subject,
+			id.Role,
+			method,
+			role,
+		)
+	}
+	return auth.NewContext(ctx, id), nil
+}
+
+func unaryAuthInterceptor(authenticator auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, authenticator, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamAuthInterceptor(authenticator auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), authenticator, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
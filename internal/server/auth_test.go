@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type fakeAuthenticator map[string]auth.Identity
+
+func (f fakeAuthenticator) Authenticate(token string) (auth.Identity, error) {
+	id, ok := f[token]
+	if !ok {
+		return auth.Identity{}, auth.ErrInvalidToken
+	}
+	return id, nil
+}
+
+var testTokens = fakeAuthenticator{
+	"player":    {Subject: "alice", Role: auth.RolePlayer},
+	"moderator": {Subject: "frontend", Role: auth.RoleModerator},
+	"admin":     {Subject: "ops", Role: auth.RoleAdmin},
+}
+
+func TestAuthorize(t *testing.T) {
+	const unknownMethod = "/dhangkanna.v1.GameService/SomethingNew"
+	tests := []struct {
+		method        string
+		authorization string
+		want          codes.Code
+	}{
+		// health probes don't need credentials.
+		{healthpb.Health_Check_FullMethodName, "", codes.OK},
+		{healthpb.Health_Watch_FullMethodName, "", codes.OK},
+
+		{dhangkannav1.GameService_Guess_FullMethodName, "", codes.Unauthenticated},
+		{dhangkannav1.GameService_Guess_FullMethodName, "player", codes.Unauthenticated},
+		{dhangkannav1.GameService_Guess_FullMethodName, "Basic player", codes.Unauthenticated},
+		{dhangkannav1.GameService_Guess_FullMethodName, "Bearer nope", codes.Unauthenticated},
+
+		{dhangkannav1.GameService_Guess_FullMethodName, "Bearer player", codes.OK},
+		{dhangkannav1.GameService_GetGame_FullMethodName, "Bearer player", codes.OK},
+		{dhangkannav1.GameService_ListServers_FullMethodName, "Bearer player", codes.OK},
+		{dhangkannav1.GameService_WatchServers_FullMethodName, "Bearer player", codes.OK},
+		{dhangkannav1.GameService_ResetGame_FullMethodName, "Bearer player", codes.PermissionDenied},
+		{api.GameService_Send_FullMethodName, "Bearer player", codes.OK},
+		{api.GameService_Reset_FullMethodName, "Bearer player", codes.PermissionDenied},
+		{api.AdminService_AddVoter_FullMethodName, "Bearer player", codes.PermissionDenied},
+		{unknownMethod, "Bearer player", codes.PermissionDenied},
+
+		{dhangkannav1.GameService_ResetGame_FullMethodName, "Bearer moderator", codes.OK},
+		{api.GameService_Reset_FullMethodName, "Bearer moderator", codes.OK},
+		{dhangkannav1.GameService_Guess_FullMethodName, "Bearer moderator", codes.OK},
+		{api.AdminService_AddVoter_FullMethodName, "Bearer moderator", codes.PermissionDenied},
+		{api.AdminService_Restore_FullMethodName, "Bearer moderator", codes.PermissionDenied},
+		{unknownMethod, "Bearer moderator", codes.PermissionDenied},
+
+		{api.AdminService_AddVoter_FullMethodName, "Bearer admin", codes.OK},
+		{api.AdminService_Restore_FullMethodName, "Bearer admin", codes.OK},
+		{dhangkannav1.GameService_ResetGame_FullMethodName, "Bearer admin", codes.OK},
+		{unknownMethod, "Bearer admin", codes.OK},
+	}
+	interceptor := unaryAuthInterceptor(testTokens)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.authorization, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+			called := false
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(context.Context, any) (any, error) {
+				called = true
+				return nil, nil
+			})
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("got %s (%v), want %s", got, err, tt.want)
+			}
+			if called != (tt.want == codes.OK) {
+				t.Fatalf("handler called = %v, want %v", called, tt.want == codes.OK)
+			}
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamAuthInterceptorPassesIdentity(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer player"))
+	info := &grpc.StreamServerInfo{FullMethod: dhangkannav1.GameService_WatchServers_FullMethodName}
+	err := streamAuthInterceptor(testTokens)(nil, &fakeServerStream{ctx: ctx}, info, func(_ any, stream grpc.ServerStream) error {
+		id, ok := auth.FromContext(stream.Context())
+		if !ok || id != testTokens["player"] {
+			t.Fatalf("got identity %+v, %v, want %+v", id, ok, testTokens["player"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.FullMethod = api.AdminService_Backup_FullMethodName
+	err = streamAuthInterceptor(testTokens)(nil, &fakeServerStream{ctx: ctx}, info, func(any, grpc.ServerStream) error {
+		t.Fatal("handler called for a player backing up the cluster")
+		return nil
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got %v, want PermissionDenied", err)
+	}
+}
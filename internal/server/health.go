@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// healthWatchInterval is how often Watch checks for a status change.
+const healthWatchInterval = time.Second
+
+// healthServices are the services reported by the health service, the
+// empty name stands for the server as a whole.
+var healthServices = map[string]bool{
+	"": true,
+	dhangkannav1.GameService_ServiceDesc.ServiceName: true,
+	api.GameService_ServiceDesc.ServiceName:          true,
+}
+
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	checker HealthChecker
+}
+
+var _ healthpb.HealthServer = (*healthServer)(nil)
+
+func newHealthServer(checker HealthChecker) *healthServer {
+	return &healthServer{checker: checker}
+}
+
+func (h *healthServer) Check(_ context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !healthServices[req.Service] {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: h.status()}, nil
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		current := healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		if healthServices[req.Service] {
+			current = h.status()
+		}
+		if current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthServer) status() healthpb.HealthCheckResponse_ServingStatus {
+	if h.checker != nil && h.checker.Ready() != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// HealthChecker tells whether the node is ready to serve, a nil error
+// means it is.
+type HealthChecker interface {
+	Ready() error
+}
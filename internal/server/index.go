@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+)
+
+// unaryIndexInterceptor reports the applied index of the server in the
+// trailer of every call, the picker of the clients steers reads away from
+// followers lagging behind.
+func unaryIndexInterceptor(g *game.DistributedGame) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		// read after the handler so writes report the entry they applied.
+		applied := strconv.FormatUint(g.Raft.AppliedIndex(), 10)
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(loadbalance.AppliedIndexKey, applied))
+		return resp, err
+	}
+}
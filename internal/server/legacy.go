@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/game"
+)
+
+var _ api.GameServiceServer = (*legacyServer)(nil)
+
+// legacyServer serves game.GameService, the API before dhangkanna.v1, so
+// clients keep working while they move to the new one.
+type legacyServer struct {
+	api.UnimplementedGameServiceServer
+	*grpcServer
+}
+
+func (s *legacyServer) Send(ctx context.Context, letter *api.Letter) (*api.SendResponse, error) {
+	if _, err := s.guess(ctx, letter.Letter); err != nil {
+		return nil, err
+	}
+	return &api.SendResponse{}, nil
+}
+
+func (s *legacyServer) Receive(ctx context.Context, _ *api.ReceiveRequest) (*api.Game, error) {
+	g, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return game.ConvertGameToGameApi(g), nil
+}
+
+func (s *legacyServer) Reset(ctx context.Context, _ *api.ResetRequest) (*api.ResetResponse, error) {
+	if _, err := s.reset(ctx); err != nil {
+		return nil, err
+	}
+	return &api.ResetResponse{}, nil
+}
+
+func (s *legacyServer) GetServers(_ context.Context, _ *api.GetServersRequest) (*api.GetServersResponse, error) {
+	servers, err := s.GetServerer.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetServersResponse{Servers: servers}, nil
+}
+
+func (s *legacyServer) WatchServers(_ *api.WatchServersRequest, stream api.GameService_WatchServersServer) error {
+	return s.watchServers(stream.Context(), func(servers []*api.Server) error {
+		return stream.Send(&api.GetServersResponse{Servers: servers})
+	})
+}
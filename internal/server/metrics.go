@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"github.com/khatibomar/dhangkanna/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func unaryMetricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+func streamMetricsInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
+func observeRPC(method string, start time.Time, err error) {
+	metrics.RPCDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	metrics.RPCRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"time"
+)
+
+// limitedMethods are the RPCs that append to the raft log on behalf of a
+// player, they are the ones worth protecting from spam.
+var limitedMethods = map[string]bool{
+	dhangkannav1.GameService_Guess_FullMethodName: true,
+	api.GameService_Send_FullMethodName:           true,
+}
+
+func unaryRateLimitInterceptor(limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !limitedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if ok, retryAfter := limiter.Allow(clientKey(ctx)); !ok {
+			return nil, resourceExhausted(ctx, retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// clientKey identifies the caller by its authenticated subject, or by its
+// IP when the server doesn't authenticate calls. Frontends call for all of
+// their players with a single token, so callers with the moderator role
+// can name the player they call for and get a bucket per player.
+func clientKey(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		key := "subject:" + id.Subject
+		if player := ratelimit.IncomingPlayer(ctx); player != "" && id.Role >= auth.RoleModerator {
+			key += "/player:" + player
+		}
+		return key
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return "ip:" + p.Addr.String()
+		}
+		return "ip:" + host
+	}
+	return ""
+}
+
+// resourceExhausted tells the client how long to back off, both as a
+// RetryInfo detail and as a retry-after header for clients that don't
+// decode details.
+func resourceExhausted(ctx context.Context, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", fmt.Sprint(seconds)))
+	st, err := status.New(
+		codes.ResourceExhausted,
+		fmt.Sprintf("too many guesses, retry in %s", retryAfter.Round(time.Millisecond)),
+	).WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "too many guesses")
+	}
+	return st.Err()
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"github.com/khatibomar/dhangkanna/internal/auth"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"testing"
+)
+
+func TestClientKey(t *testing.T) {
+	withPlayer := func(ctx context.Context, player string) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(ratelimit.PlayerKey, player))
+	}
+	withID := func(ctx context.Context, role auth.Role) context.Context {
+		return auth.NewContext(ctx, auth.Identity{Subject: "frontend", Role: role})
+	}
+	peerCtx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"moderator naming a player", withPlayer(withID(peerCtx, auth.RoleModerator), "1.2.3.4"), "subject:frontend/player:1.2.3.4"},
+		{"admin naming a player", withPlayer(withID(peerCtx, auth.RoleAdmin), "1.2.3.4"), "subject:frontend/player:1.2.3.4"},
+		{"moderator without a player", withID(peerCtx, auth.RoleModerator), "subject:frontend"},
+		{"player can't name another one", withPlayer(withID(peerCtx, auth.RolePlayer), "1.2.3.4"), "subject:frontend"},
+		{"no auth ignores the player", withPlayer(peerCtx, "1.2.3.4"), "ip:10.0.0.1"},
+		{"no auth", peerCtx, "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientKey(tt.ctx); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
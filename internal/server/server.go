@@ -2,33 +2,64 @@ package server
 
 import (
 	"context"
+	"errors"
+	"github.com/hashicorp/raft"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
 	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/auth"
 	"github.com/khatibomar/dhangkanna/internal/game"
+	"github.com/khatibomar/dhangkanna/internal/loadbalance"
+	"github.com/khatibomar/dhangkanna/internal/logging"
+	"github.com/khatibomar/dhangkanna/internal/metrics"
+	"github.com/khatibomar/dhangkanna/internal/ratelimit"
+	"github.com/khatibomar/dhangkanna/internal/telemetry"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/emptypb"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 )
 
-var _ api.GameServiceServer = (*grpcServer)(nil)
+var tracer = otel.Tracer("github.com/khatibomar/dhangkanna/internal/server")
 
 type Config struct {
 	Game        *game.DistributedGame
 	GetServerer GetServerer
+	// ServerWatcher tells WatchServers when to send the servers again,
+	// nil only sends them periodically.
+	ServerWatcher ServerWatcher
+	Administrator Administrator
+	Keyring       Keyring
+	// HealthChecker backs the grpc.health.v1 service, nil always reports
+	// serving.
+	HealthChecker HealthChecker
+	// Authenticator enables bearer token authorization, nil lets every
+	// call through.
+	Authenticator auth.Authenticator
+	// GuessLimiter rate limits Send per client, nil disables it.
+	GuessLimiter *ratelimit.Limiter
+	// Reflection registers the gRPC reflection service so generic tools
+	// like grpcurl can list and call the services.
+	Reflection bool
+	// Logger receives the server logs, nil uses slog.Default.
+	Logger *slog.Logger
 }
 
+// grpcServer plays the game, the services of both API versions call it.
 type grpcServer struct {
-	api.UnimplementedGameServiceServer
 	*Config
-	logger *log.Logger
+	logger *slog.Logger
 }
 
 func newGrpcServer(config *Config) (srv *grpcServer, err error) {
 	srv = &grpcServer{
 		Config: config,
-		logger: log.New(os.Stdout, "grpc server: ", log.LstdFlags),
+		logger: logging.Component(config.Logger, "server"),
 	}
 	return srv, nil
 }
@@ -37,65 +68,186 @@ func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (
 	*grpc.Server,
 	error,
 ) {
+	// metrics come first so rejected calls are counted too.
+	unary := []grpc.UnaryServerInterceptor{unaryMetricsInterceptor}
+	stream := []grpc.StreamServerInterceptor{streamMetricsInterceptor}
+	if config.Game != nil {
+		unary = append(unary, unaryIndexInterceptor(config.Game))
+	}
+	if config.Authenticator != nil {
+		unary = append(unary, unaryAuthInterceptor(config.Authenticator))
+		stream = append(stream, streamAuthInterceptor(config.Authenticator))
+	}
+	// runs after auth so clients are limited by subject rather than IP.
+	if config.GuessLimiter != nil {
+		unary = append(unary, unaryRateLimitInterceptor(config.GuessLimiter))
+	}
+	grpcOpts = append(grpcOpts,
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
 	gsrv := grpc.NewServer(grpcOpts...)
 	srv, err := newGrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
-	api.RegisterGameServiceServer(gsrv, srv)
+	dhangkannav1.RegisterGameServiceServer(gsrv, &v1Server{grpcServer: srv})
+	api.RegisterGameServiceServer(gsrv, &legacyServer{grpcServer: srv})
+	healthpb.RegisterHealthServer(gsrv, newHealthServer(config.HealthChecker))
+	if config.Administrator != nil {
+		api.RegisterAdminServiceServer(gsrv, newAdminServer(config))
+	}
+	if config.Reflection {
+		reflection.Register(gsrv)
+	}
 	return gsrv, nil
 }
 
-func (s *grpcServer) Send(_ context.Context, letter *api.Letter) (*emptypb.Empty, error) {
-	s.logger.Printf("Received new letter %s", letter)
-	s.Game.HandleNewLetter(letter.Letter)
+// guess applies letter to the game and returns the game it led to.
+func (s *grpcServer) guess(ctx context.Context, letter string) (game.Game, error) {
+	s.logger.DebugContext(ctx, "received new letter", "letter", letter)
+	if err := s.checkLeader(); err != nil {
+		return game.Game{}, err
+	}
+	s.Game.HandleNewLetter(letter)
+	g := s.Game.Copy()
+	if err := s.apply(ctx, g); err != nil {
+		return game.Game{}, err
+	}
+	metrics.Guesses.Inc()
+	switch g.GameState {
+	case game.Won:
+		metrics.Wins.Inc()
+	case game.Lost:
+		metrics.Losses.Inc()
+	}
+	return g, nil
+}
 
-	g := game.Game{
-		GuessedCharacter: s.Game.GuessedCharacter,
-		IncorrectGuesses: s.Game.IncorrectGuesses,
-		ChancesLeft:      s.Game.ChancesLeft,
-		GameState:        s.Game.GameState,
-		Message:          s.Game.Message,
-		Version:          s.Game.Version,
+func (s *grpcServer) read(ctx context.Context) (game.Game, error) {
+	consistency := loadbalance.IncomingConsistency(ctx)
+	s.logger.DebugContext(ctx, "reading game state", "consistency", consistency)
+	if consistency == loadbalance.Strong {
+		if err := s.checkLeader(); err != nil {
+			return game.Game{}, err
+		}
+		// a partitioned leader doesn't know it was replaced until it
+		// fails to reach a quorum.
+		if err := s.Game.Raft.VerifyLeader().Error(); err != nil {
+			return game.Game{}, s.notLeader()
+		}
 	}
+	return s.Game.Copy(), nil
+}
 
-	b, err := proto.Marshal(game.ConvertGameToGameApi(g))
-	if err != nil {
-		return &emptypb.Empty{}, err
+func (s *grpcServer) reset(ctx context.Context) (game.Game, error) {
+	s.logger.InfoContext(ctx, "reset received")
+	if err := s.checkLeader(); err != nil {
+		return game.Game{}, err
+	}
+	s.Game.Reset()
+	g := s.Game.Copy()
+	if err := s.apply(ctx, g); err != nil {
+		return game.Game{}, err
 	}
-	s.Game.Raft.Apply(b, 5*time.Second)
-	return &emptypb.Empty{}, nil
+	s.logger.InfoContext(ctx, "reset completed")
+	return g, nil
 }
 
-func (s *grpcServer) Receive(_ context.Context, _ *emptypb.Empty) (*api.Game, error) {
-	s.logger.Println("this server handling reading game state")
-	st := &api.Game{
-		GuessedCharacter: s.Game.GuessedCharacter,
-		IncorrectGuesses: s.Game.IncorrectGuesses,
-		ChancesLeft:      int32(s.Game.ChancesLeft),
-		GameState:        int32(s.Game.GameState),
-		Message:          s.Game.Message,
-		Version:          int32(s.Game.Version),
+// checkLeader runs before touching the game, so a call reaching a
+// follower changes nothing and can be retried on the leader.
+func (s *grpcServer) checkLeader() error {
+	if s.Game.Raft.State() != raft.Leader {
+		return s.notLeader()
 	}
+	return nil
+}
 
-	return st, nil
+func (s *grpcServer) notLeader() error {
+	return notLeaderError(s.Game)
 }
 
-func (s *grpcServer) Reset(_ context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
-	s.logger.Println("Reset received")
-	s.Game.Reset()
-	s.logger.Println("Reset completed")
-	return &emptypb.Empty{}, nil
+// notLeaderError points the client to the leader g knows of, if any.
+func notLeaderError(g *game.DistributedGame) error {
+	if g == nil {
+		return loadbalance.NotLeaderError("", "")
+	}
+	leaderAddr, leaderID := g.Raft.LeaderWithID()
+	return loadbalance.NotLeaderError(string(leaderID), string(leaderAddr))
 }
 
-func (s *grpcServer) GetServers(_ context.Context, _ *emptypb.Empty) (*api.GetServersResponse, error) {
-	servers, err := s.GetServerer.GetServers()
+// apply replicates g and waits for the leader to apply it.
+func (s *grpcServer) apply(ctx context.Context, g game.Game) error {
+	b, err := proto.Marshal(game.ConvertGameToGameApi(g))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	ctx, span := tracer.Start(ctx, "raft.Apply")
+	defer span.End()
+	start := time.Now()
+	// the trace context rides along the entry so every FSM applying it
+	// joins this trace.
+	future := s.Game.Raft.ApplyLog(raft.Log{
+		Data:       b,
+		Extensions: telemetry.Inject(ctx),
+	}, 5*time.Second)
+	if err := future.Error(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		// the entry never made it to the log in both cases, unlike
+		// raft.ErrLeadershipLost where it can still be committed.
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipTransferInProgress) {
+			return s.notLeader()
+		}
+		return err
+	}
+	metrics.ApplyDuration.Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.Int64("raft.index", int64(future.Index())))
+	return nil
+}
+
+// serversRefreshInterval is how often WatchServers checks the servers
+// without being told about a change.
+const serversRefreshInterval = 5 * time.Second
+
+// watchServers calls send with the servers right away and again whenever
+// they change, until ctx is done.
+func (s *grpcServer) watchServers(ctx context.Context, send func([]*api.Server) error) error {
+	var changes <-chan struct{}
+	if s.ServerWatcher != nil {
+		var stop func()
+		changes, stop = s.ServerWatcher.WatchServers()
+		defer stop()
+	}
+	ticker := time.NewTicker(serversRefreshInterval)
+	defer ticker.Stop()
+	var last *api.GetServersResponse
+	for {
+		servers, err := s.GetServerer.GetServers()
+		if err != nil {
+			return err
+		}
+		res := &api.GetServersResponse{Servers: servers}
+		if !proto.Equal(res, last) {
+			if err := send(servers); err != nil {
+				return err
+			}
+			last = res
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+		case <-ticker.C:
+		}
 	}
-	return &api.GetServersResponse{Servers: servers}, nil
 }
 
 type GetServerer interface {
 	GetServers() ([]*api.Server, error)
 }
+
+type ServerWatcher interface {
+	WatchServers() (changes <-chan struct{}, stop func())
+}
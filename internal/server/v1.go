@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	dhangkannav1 "github.com/khatibomar/dhangkanna/api/dhangkanna/v1"
+	api "github.com/khatibomar/dhangkanna/cmd/api/v1"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ dhangkannav1.GameServiceServer = (*v1Server)(nil)
+
+// v1Server serves dhangkanna.v1.GameService.
+type v1Server struct {
+	dhangkannav1.UnimplementedGameServiceServer
+	*grpcServer
+}
+
+func (s *v1Server) GetGame(ctx context.Context, req *dhangkannav1.GetGameRequest) (*dhangkannav1.GetGameResponse, error) {
+	if err := checkGameID(req.GameId); err != nil {
+		return nil, err
+	}
+	g, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dhangkannav1.GetGameResponse{Game: game.ConvertGameToGameV1(req.GameId, g)}, nil
+}
+
+func (s *v1Server) Guess(ctx context.Context, req *dhangkannav1.GuessRequest) (*dhangkannav1.GuessResponse, error) {
+	if err := checkGameID(req.GameId); err != nil {
+		return nil, err
+	}
+	if req.Letter == "" {
+		return nil, status.Error(codes.InvalidArgument, "letter is required")
+	}
+	g, err := s.guess(ctx, req.Letter)
+	if err != nil {
+		return nil, err
+	}
+	return &dhangkannav1.GuessResponse{Game: game.ConvertGameToGameV1(req.GameId, g)}, nil
+}
+
+func (s *v1Server) ResetGame(ctx context.Context, req *dhangkannav1.ResetGameRequest) (*dhangkannav1.ResetGameResponse, error) {
+	if err := checkGameID(req.GameId); err != nil {
+		return nil, err
+	}
+	g, err := s.reset(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &dhangkannav1.ResetGameResponse{Game: game.ConvertGameToGameV1(req.GameId, g)}, nil
+}
+
+func (s *v1Server) ListServers(_ context.Context, _ *dhangkannav1.ListServersRequest) (*dhangkannav1.ListServersResponse, error) {
+	servers, err := s.GetServerer.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &dhangkannav1.ListServersResponse{Servers: convertServers(servers)}, nil
+}
+
+func (s *v1Server) WatchServers(_ *dhangkannav1.WatchServersRequest, stream dhangkannav1.GameService_WatchServersServer) error {
+	return s.watchServers(stream.Context(), func(servers []*api.Server) error {
+		return stream.Send(&dhangkannav1.WatchServersResponse{Servers: convertServers(servers)})
+	})
+}
+
+// checkGameID rejects the games the cluster doesn't have, it runs a single
+// one for now.
+func checkGameID(id string) error {
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "game_id is required")
+	}
+	if id != game.DefaultID {
+		return status.Error(codes.NotFound, fmt.Sprintf("unknown game %q", id))
+	}
+	return nil
+}
+
+func convertServers(servers []*api.Server) []*dhangkannav1.Server {
+	converted := make([]*dhangkannav1.Server, 0, len(servers))
+	for _, srv := range servers {
+		converted = append(converted, &dhangkannav1.Server{
+			Id:         srv.Id,
+			RpcAddr:    srv.RpcAddr,
+			IsLeader:   srv.IsLeader,
+			IsNonvoter: srv.IsNonvoter,
+		})
+	}
+	return converted
+}
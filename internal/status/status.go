@@ -0,0 +1,173 @@
+// Package status serves a snapshot of a node, its raft state, the serf
+// members it sees and its games, as JSON and as an HTML page.
+package status
+
+import (
+	"encoding/json"
+	"github.com/khatibomar/dhangkanna/internal/game"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+type Status struct {
+	Node string `json:"node"`
+	// Live and Ready hold the failing check, empty when it passes.
+	Live    string   `json:"live,omitempty"`
+	Ready   string   `json:"ready,omitempty"`
+	Raft    Raft     `json:"raft"`
+	Members []Member `json:"members"`
+	Games   []Game   `json:"games"`
+	// Time is when the status was taken.
+	Time time.Time `json:"time"`
+}
+
+type Raft struct {
+	State        string       `json:"state"`
+	Term         uint64       `json:"term"`
+	LastLogIndex uint64       `json:"lastLogIndex"`
+	CommitIndex  uint64       `json:"commitIndex"`
+	AppliedIndex uint64       `json:"appliedIndex"`
+	LastContact  string       `json:"lastContact,omitempty"`
+	LeaderID     string       `json:"leaderId,omitempty"`
+	LeaderAddr   string       `json:"leaderAddr,omitempty"`
+	Servers      []RaftServer `json:"servers"`
+	Error        string       `json:"error,omitempty"`
+}
+
+type RaftServer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+}
+
+type Member struct {
+	Name   string            `json:"name"`
+	Addr   string            `json:"addr"`
+	Status string            `json:"status"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type Game struct {
+	ID string `json:"id"`
+	game.Game
+}
+
+// Source takes the status of the node, it is called on every request.
+type Source func() *Status
+
+// JSONHandler serves the status as JSON.
+func JSONHandler(source Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(source())
+	})
+}
+
+// HTMLHandler serves the status as a page refreshing itself.
+func HTMLHandler(source Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, source()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+var page = template.Must(template.New("status").Funcs(template.FuncMap{
+	"gameState": gameState,
+}).Parse(pageTemplate))
+
+func gameState(state int8) string {
+	switch state {
+	case game.Start:
+		return "start"
+	case game.Going:
+		return "going"
+	case game.Won:
+		return "won"
+	case game.Lost:
+		return "lost"
+	default:
+		return "unknown"
+	}
+}
+
+const pageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>{{.Node}} - dhangkanna</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.ok { color: green; }
+.bad { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Node}}</h1>
+<p>
+live: {{if .Live}}<span class="bad">{{.Live}}</span>{{else}}<span class="ok">ok</span>{{end}},
+ready: {{if .Ready}}<span class="bad">{{.Ready}}</span>{{else}}<span class="ok">ok</span>{{end}},
+taken at {{.Time.Format "2006-01-02 15:04:05 MST"}} (<a href="/status">json</a>)
+</p>
+
+<h2>Raft</h2>
+{{with .Raft}}
+{{if .Error}}<p class="bad">{{.Error}}</p>{{end}}
+<table>
+<tr><th>state</th><td>{{.State}}</td></tr>
+<tr><th>term</th><td>{{.Term}}</td></tr>
+<tr><th>last log index</th><td>{{.LastLogIndex}}</td></tr>
+<tr><th>commit index</th><td>{{.CommitIndex}}</td></tr>
+<tr><th>applied index</th><td>{{.AppliedIndex}}</td></tr>
+<tr><th>last contact</th><td>{{.LastContact}}</td></tr>
+<tr><th>leader</th><td>{{if .LeaderID}}{{.LeaderID}} ({{.LeaderAddr}}){{else}}<span class="bad">unknown</span>{{end}}</td></tr>
+</table>
+
+<h3>Configuration</h3>
+<table>
+<tr><th>id</th><th>address</th><th>suffrage</th><th></th></tr>
+{{range .Servers}}
+<tr><td>{{.ID}}</td><td>{{.Address}}</td><td>{{.Suffrage}}</td><td>{{if .Leader}}leader{{end}}</td></tr>
+{{end}}
+</table>
+{{end}}
+
+<h2>Serf members</h2>
+<table>
+<tr><th>name</th><th>address</th><th>status</th><th>tags</th></tr>
+{{range .Members}}
+<tr>
+<td>{{.Name}}</td><td>{{.Addr}}</td>
+<td class="{{if eq .Status "alive"}}ok{{else}}bad{{end}}">{{.Status}}</td>
+<td>{{range $k, $v := .Tags}}{{$k}}={{$v}} {{end}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Games</h2>
+<table>
+<tr><th>id</th><th>state</th><th>word</th><th>incorrect guesses</th><th>chances left</th><th>version</th></tr>
+{{range .Games}}
+<tr>
+<td>{{.ID}}</td><td>{{gameState .GameState}}</td>
+<td>{{range .GuessedCharacter}}{{.}} {{end}}</td>
+<td>{{range .IncorrectGuesses}}{{.}} {{end}}</td>
+<td>{{.ChancesLeft}}</td><td>{{.Version}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`
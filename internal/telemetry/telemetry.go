@@ -0,0 +1,143 @@
+// Package telemetry sets up OpenTelemetry tracing and carries trace
+// context where gRPC can't, across the raft log.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"os"
+)
+
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type Config struct {
+	ServiceName string
+	// InstanceID tells apart the processes of the same service, the node
+	// name for backends.
+	InstanceID string
+	// Exporter is one of the Exporter constants, ExporterNone still
+	// propagates the trace context of callers but records nothing.
+	Exporter string
+	// OTLPEndpoint is the host:port of the collector, empty uses the
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	OTLPEndpoint string
+	OTLPInsecure bool
+	// File receives spans as JSON lines with ExporterFile.
+	File string
+}
+
+// Setup installs the global tracer provider and propagator, the returned
+// function flushes pending spans and must be called before exiting.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if config.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+		semconv.ServiceInstanceID(config.InstanceID),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closer.Close())
+	}, nil
+}
+
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch config.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		return exporter, nopCloser{}, err
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, nopCloser{}, err
+	case ExporterFile:
+		if config.File == "" {
+			return nil, nil, fmt.Errorf("the file exporter needs a file")
+		}
+		f, err := os.OpenFile(config.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return nil, nil, errors.Join(err, f.Close())
+		}
+		return exporter, f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+}
+
+// nopCloser is the closer of exporters that don't own a file.
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Inject encodes the trace context of ctx, it is stored in the extensions
+// of raft log entries so FSM applies join the trace of the write.
+func Inject(ctx context.Context) []byte {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(carrier)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Extract is the reverse of Inject, entries written without a trace
+// context give back ctx as is.
+func Extract(ctx context.Context, b []byte) context.Context {
+	if len(b) == 0 {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{}
+	if err := json.Unmarshal(b, &carrier); err != nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}